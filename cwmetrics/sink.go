@@ -0,0 +1,98 @@
+// Package cwmetrics provides a ssmclient.MetricsSink which publishes session metrics to Amazon CloudWatch using the
+// PutMetricData API.  It is a separate package so that programs which don't use it don't depend on the CloudWatch
+// API client.
+package cwmetrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/mmmorris1975/ssm-session-client/ssmclient"
+)
+
+// DefaultNamespace is the CloudWatch namespace used if Sink.Namespace is not set.
+const DefaultNamespace = "SSMSessionClient"
+
+// DefaultTimeout is the time allowed for the PutMetricData call if Sink.Timeout is not set.
+const DefaultTimeout = 5 * time.Second
+
+// Metric names published for each session.
+const (
+	MetricDuration      = "SessionDuration"
+	MetricBytesSent     = "BytesSent"
+	MetricBytesReceived = "BytesReceived"
+	MetricReconnects    = "Reconnects"
+)
+
+// Sink publishes the metrics of each session to CloudWatch.  The metrics have a SessionType dimension (the
+// ssmclient.SessionMode of the session), and a Target dimension unless OmitTarget is set, which reduces the number
+// of metrics published for large fleets.  The PutMetricData call is made when the session ends, blocking the close
+// of the session for up to Timeout.  A Sink must be created with NewSink.
+// Namespace is the CloudWatch namespace for the metrics, DefaultNamespace is used if not set.
+// Timeout limits how long the PutMetricData call may take, DefaultTimeout is used if not set.
+// OmitTarget publishes the metrics without the Target dimension.
+// OnError is an optional function called with errors from the PutMetricData call, which are otherwise discarded.
+type Sink struct {
+	Namespace  string        // optional
+	Timeout    time.Duration // optional
+	OmitTarget bool          // optional
+	OnError    func(error)   // optional
+
+	client *cloudwatch.Client
+}
+
+// NewSink returns a Sink which publishes metrics to the provided namespace, using the aws.Config to create the
+// CloudWatch API client.
+func NewSink(cfg aws.Config, namespace string) *Sink {
+	return &Sink{Namespace: namespace, client: cloudwatch.NewFromConfig(cfg)}
+}
+
+// RecordSession publishes the metrics for the session, implementing ssmclient.MetricsSink.
+func (s *Sink) RecordSession(m ssmclient.SessionMetrics) {
+	ns := s.Namespace
+	if len(ns) < 1 {
+		ns = DefaultNamespace
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	in := &cloudwatch.PutMetricDataInput{Namespace: aws.String(ns), MetricData: s.metricData(m)}
+	if _, err := s.client.PutMetricData(ctx, in); err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// metricData returns the CloudWatch metric data for the session.
+func (s *Sink) metricData(m ssmclient.SessionMetrics) []types.MetricDatum {
+	dims := []types.Dimension{{Name: aws.String("SessionType"), Value: aws.String(m.Mode.String())}}
+	if !s.OmitTarget && len(m.Target) > 0 {
+		dims = append(dims, types.Dimension{Name: aws.String("Target"), Value: aws.String(m.Target)})
+	}
+
+	ts := aws.Time(m.Start.Add(m.Duration))
+	datum := func(name string, value float64, unit types.StandardUnit) types.MetricDatum {
+		return types.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dims,
+			Timestamp:  ts,
+			Unit:       unit,
+			Value:      aws.Float64(value),
+		}
+	}
+
+	return []types.MetricDatum{
+		datum(MetricDuration, m.Duration.Seconds(), types.StandardUnitSeconds),
+		datum(MetricBytesSent, float64(m.BytesSent), types.StandardUnitBytes),
+		datum(MetricBytesReceived, float64(m.BytesReceived), types.StandardUnitBytes),
+		datum(MetricReconnects, float64(m.Reconnects), types.StandardUnitCount),
+	}
+}
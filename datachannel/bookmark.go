@@ -0,0 +1,96 @@
+package datachannel
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// Bookmark is the session metadata saved to the file configured in SsmDataChannel.BookmarkPath.
+type Bookmark struct {
+	SessionID    string    `json:"SessionId"`
+	StreamURL    string    `json:"StreamUrl"`
+	TokenValue   string    `json:"TokenValue"`
+	Target       string    `json:"Target"`
+	DocumentName string    `json:"DocumentName,omitempty"`
+	Created      time.Time `json:"Created"`
+}
+
+func newBookmark(out *ssm.StartSessionOutput, in *ssm.StartSessionInput) *Bookmark {
+	return &Bookmark{
+		SessionID:    aws.ToString(out.SessionId),
+		StreamURL:    aws.ToString(out.StreamUrl),
+		TokenValue:   aws.ToString(out.TokenValue),
+		Target:       aws.ToString(in.Target),
+		DocumentName: aws.ToString(in.DocumentName),
+		Created:      time.Now(),
+	}
+}
+
+// ReadBookmark loads the Bookmark saved in the file at path.
+func ReadBookmark(path string) (*Bookmark, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Bookmark)
+	if err = json.Unmarshal(data, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// write saves the bookmark to the file at path.  The file contains the session token, so it is only readable
+// by the owner.
+func (b *Bookmark) write(path string) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// ResumeFromBookmark reattaches to the session saved in the bookmark file at path, using the ResumeSession API
+// to get a new stream URL and token, and opens the data channel.  This is used in place of Open().
+//
+// Resuming is only possible while the session is still active on the agent side, meaning it has not been
+// terminated, and the Session Manager idle timeout (20 minutes by default) has not expired.  The StreamUrl
+// and TokenValue stored in the bookmark are only valid for a short time after the session is started, which is
+// why ResumeSession is always called.  Any data in flight when the previous client exited is not recovered,
+// and port forwarding sessions will need to repeat the handshake.
+func (c *SsmDataChannel) ResumeFromBookmark(cfg aws.Config, path string) error {
+	b, err := ReadBookmark(path)
+	if err != nil {
+		return err
+	}
+
+	in := &ssm.ResumeSessionInput{SessionId: aws.String(b.SessionID)}
+	cfg = withDefaultUserAgent(cfg)
+	out, err := ssm.NewFromConfig(cfg).ResumeSession(context.Background(), in)
+	if err != nil {
+		return err
+	}
+
+	if len(c.BookmarkPath) > 0 {
+		b.StreamURL = aws.ToString(out.StreamUrl)
+		b.TokenValue = aws.ToString(out.TokenValue)
+		if err = b.write(c.BookmarkPath); err != nil {
+			return err
+		}
+	}
+
+	c.init()
+	c.awsCfg = &cfg
+	c.sessionID = b.SessionID
+	c.streamURL = aws.ToString(out.StreamUrl)
+	c.target = b.Target
+	if c.OnSessionStart != nil {
+		c.OnSessionStart(c.sessionID)
+	}
+	return c.connect(aws.ToString(out.StreamUrl), aws.ToString(out.TokenValue), false)
+}
@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,6 +22,42 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// dialAttempts is the maximum number of times the websocket dial will be attempted.  The token returned by the
+	// StartSession API call is only valid for a short time, so keep this small.
+	dialAttempts = 3
+	// dialBackoff is the delay before the 1st dial retry, and is doubled for each subsequent retry.
+	dialBackoff = 250 * time.Millisecond
+
+	// clientVersion is the version reported to the agent in the handshake response.  It seems this can be whatever
+	// we need it to be, however certain features may only be available at certain client versions (must report at
+	// least version 1.1.70 to do stream muxing, which is only reported if SsmDataChannel.Multiplex is set).
+	clientVersion = "0.0.1"
+	// muxClientVersion and muxAgentVersion are the minimum client and agent versions which will use multiplexing
+	// for port forwarding sessions.
+	// REF: https://github.com/aws/session-manager-plugin/blob/mainline/src/sessionmanagerplugin/session/portsession/portsession.go
+	muxClientVersion = "1.1.70"
+	muxAgentVersion  = "3.0.196.0"
+)
+
+// DefaultMaxPayloadSize is the largest payload sent in a single message by Write(), unless changed by setting
+// SsmDataChannel.MaxPayloadSize.  This is the same size used by the AWS session manager plugin.
+const DefaultMaxPayloadSize = 1024
+
+// ErrNotOpen is the error returned when writing to a data channel which was never opened, or has been closed.
+var ErrNotOpen = errors.New("data channel is not open")
+
+// ErrPayloadTooLarge is the error returned by WriteMessage if the payload is larger than the maximum payload size.
+var ErrPayloadTooLarge = errors.New("payload is larger than the maximum message payload size")
+
+// ErrAckTimeout is the error returned by WaitForAcks if messages sent to the agent were not acknowledged before the
+// timeout expired.
+var ErrAckTimeout = errors.New("timed out waiting for messages to be acknowledged")
+
+// ErrTerminateTimeout is the error returned by TerminateSessionAndWait if the agent did not acknowledge the
+// TerminateSession message before the timeout expired.
+var ErrTerminateTimeout = errors.New("timed out waiting for session termination")
+
 // DataChannel is the interface definition for handling communication with the AWS SSM messaging service.
 type DataChannel interface {
 	Open(aws.Config, *ssm.StartSessionInput) error
@@ -36,10 +74,112 @@ type DataChannel interface {
 // SsmDataChannel represents the data channel of the websocket connection used to communicate with the AWS
 // SSM service.  A new(SsmDataChannel) is ready for use, and should immediately call the Open() method.
 type SsmDataChannel struct {
+	// WriteCoalesceDelay enables buffering of the data passed to Write(), so that bursts of small writes can be
+	// sent as a single message after this delay.  The zero value disables coalescing, which is what should be
+	// used for interactive terminal sessions.
+	WriteCoalesceDelay time.Duration
+
+	// Multiplex requests stream multiplexing for port forwarding sessions, by reporting a client version to the
+	// agent which supports it.  If the agent also supports multiplexing (see SessionInfo.Multiplexing), the data
+	// sent and received over the channel is framed by the smux protocol, and must be handled by a smux client.
+	Multiplex bool
+
+	// KeepAliveInterval is the interval between websocket ping frames sent to keep an idle session from being
+	// closed by the service (or by proxies between here and the service).  If not set, DefaultKeepAliveInterval
+	// is used, and a negative value disables the pings.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveTimeout, if set, causes Read to fail with a timeout error when no message (or reply to a ping) has
+	// been received for this long, so a connection to an unresponsive service is detected.  This should be a few
+	// multiples of KeepAliveInterval.
+	KeepAliveTimeout time.Duration
+
+	// Dialer, if set, is used to connect to the data channel websocket, which allows using an outbound proxy (Proxy),
+	// custom TLS settings (TLSClientConfig), or a different HandshakeTimeout.  If not set, the proxy set in the
+	// environment (HTTPS_PROXY, NO_PROXY) is used.  A custom Dialer only uses a proxy if its Proxy field is set, use
+	// http.ProxyFromEnvironment to keep the default behavior.  This does not apply to AWS API calls, which use the
+	// HTTP client of the aws.Config (see WithDialContext).
+	Dialer *websocket.Dialer
+
+	// WriteTimeout, if set, is the time allowed to send each message over the websocket.  A write which doesn't
+	// complete in time (because the connection or the service is stuck) fails with a timeout error, and the
+	// websocket connection is closed, so that readers of the data channel also see the failure instead of hanging.
+	WriteTimeout time.Duration
+
+	// MaxReconnects, if greater than 0, enables reconnecting to the session when the websocket connection is
+	// dropped (not closed by either end of the session), up to this many times over the life of the data channel.
+	// Reconnecting uses the ResumeSession API, so it is only possible for sessions started with Open() or
+	// ResumeFromBookmark().  Read blocks while reconnecting, and writes fail until the connection is replaced.
+	// Data in flight when the connection dropped may be lost for sessions which have completed the handshake
+	// (port forwarding and ssh), since the outbound message buffer is only used until the handshake completes.
+	MaxReconnects int
+
+	// ReconnectBackoff is the delay before the 1st reconnect attempt, which is doubled for each subsequent attempt.
+	// If not set, DefaultReconnectBackoff is used.
+	ReconnectBackoff time.Duration
+
+	// MaxPayloadSize is the largest payload sent in a single message, data passed to Write() which is larger than
+	// this is split in to multiple messages.  If not set, DefaultMaxPayloadSize is used.
+	MaxPayloadSize int
+
+	// OnChannelClosed, if set, is called with the payload of the ChannelClosed message received from the agent
+	// when the session ends.
+	OnChannelClosed func(*ChannelClosedPayload)
+
+	// OnSessionStart, if set, is called with the ID of the session as soon as the session is started (by Open) or
+	// resumed (by ResumeFromBookmark), before the websocket connection is made.  The ID can be used to correlate
+	// the session with the session history and CloudTrail.
+	OnSessionStart func(sessionID string)
+
+	// OnAgentError, if set, is called with the payload of Error messages sent by the agent to report a problem
+	// within the session.  These are not fatal to the session.  If not set, the payload is logged.
+	OnAgentError func([]byte)
+
+	// OnTaskMessage, if set, is called with the TaskReply and TaskComplete messages received from the agent.  These
+	// messages are used by the agent to report the progress and result of tasks (like Run Command documents)
+	// delivered over the session messaging service, and are not sent for the Session Manager session types
+	// supported by this library (shell, port forwarding, and ssh).  The message payload is the JSON document sent
+	// by the agent.  If not set, these messages are acknowledged and otherwise ignored.
+	OnTaskMessage func(*AgentMessage)
+
+	// PreHandshake, if set, is called by Open (and StartSessionFromDataChannelURL) once the data channel is open, and
+	// before any message from the agent is processed, to send messages required by the session document before the
+	// agent starts the session handshake (like an initial terminal size, or a profile for a custom shell document).
+	// Like all messages sent before the handshake completes, these are held in the outbound buffer and resent until
+	// the agent acknowledges them.  If an error is returned, the data channel is closed, and Open returns the error.
+	// This is not called when resuming a session, since the agent has already received the messages.
+	PreHandshake func(*SsmDataChannel) error
+
+	// OnClose, if set, is called once when Close() closes the data channel, after the websocket connection is
+	// closed.  Stats() and SessionID() can be used to report on the completed session.  This is not called if the
+	// data channel is never closed, or was never opened.
+	OnClose func()
+
+	// TerminateTimeout, if greater than 0, makes TerminateSession wait up to this long for the agent to acknowledge
+	// the TerminateSession message (see TerminateSessionAndWait), so a following Close doesn't shut down the
+	// websocket before the agent has processed it.  Only set this if another goroutine is reading from the data
+	// channel while the session is terminated, otherwise TerminateSession always waits for the full timeout.
+	TerminateTimeout time.Duration
+
+	// UnknownMessagePolicy determines how messages with an unrecognized message type or payload type are handled.
+	// The default (UnknownMessageLog) logs and discards them, so an unexpected message from a newer agent doesn't
+	// end an otherwise working session.
+	UnknownMessagePolicy UnknownMessagePolicy
+
+	// BookmarkPath, if set, is the file where a Bookmark for the session is written once the session is
+	// started, so that it can be reattached to using ResumeFromBookmark().
+	BookmarkPath string
+
+	// DebugEvents, if set, is sent a DebugEvent for each step of the protocol (messages sent, acknowledged, and
+	// retransmitted, flow control, and handshake progress) to help troubleshoot stalled sessions.  Sends never
+	// block, events are dropped if the channel is full, so it should be buffered and read from another goroutine.
+	// The channel is not closed when the data channel is closed.
+	DebugEvents chan<- DebugEvent
+
 	seqNum      int64
 	inSeqNum    int64
 	mu          sync.Mutex
-	ws          *websocket.Conn
+	ws          atomic.Value // *websocket.Conn, replaced when the session is resumed (see conn)
 	synSent     bool
 	handshakeCh chan bool
 	pausePub    bool
@@ -47,38 +187,160 @@ type SsmDataChannel struct {
 	inMsgBuf    MessageBuffer
 	lastRows    uint32
 	lastCols    uint32
+	closedMsg   *ChannelClosedPayload
+	info        SessionInfo
+	stats       ChannelStats
+	closed      int32 // set when Close() is called
+	peerClosed  int32 // set when the remote end closes the connection
+
+	coalesceMu    sync.Mutex
+	coalesceBuf   []byte
+	coalesceTimer *time.Timer
+	coalesceErr   error
+
+	// used for KMS session encryption, if requested by the agent
+	awsCfg    *aws.Config
+	sessionID string
+	streamURL string
+	target    string
+	enc       *encrypter
+
+	reconnects int // number of reconnect attempts, only used by the goroutine calling Read
+
+	handshakeOutput []byte // output received before the handshake completed, returned by PendingOutput
+
+	termSeq int64         // sequence number of the TerminateSession message sent by TerminateSessionAndWait
+	termCh  chan struct{} // closed once the TerminateSession message is acknowledged, or the channel is closed
 }
 
 // Open creates the web socket connection with the AWS service and opens the data channel.
 func (c *SsmDataChannel) Open(cfg aws.Config, in *ssm.StartSessionInput) error {
+	c.init()
+	return c.startSession(cfg, in)
+}
+
+func (c *SsmDataChannel) init() {
 	c.handshakeCh = make(chan bool, 1)
 	c.outMsgBuf = NewMessageBuffer(50)
 	c.inMsgBuf = NewMessageBuffer(50)
 
 	go c.processOutboundQueue()
-
-	return c.startSession(cfg, in)
 }
 
 // Close shuts down the web socket connection with the AWS service. Type-specific actions (like sending
-// TerminateSession for port forwarding should be handled before calling Close().
+// TerminateSession for port forwarding should be handled before calling Close().  Calling Close() on a
+// data channel which is already closed does nothing.
 func (c *SsmDataChannel) Close() error {
 	var err error
-	if c.ws != nil {
-		err = c.ws.Close()
+	if ws := c.conn(); ws != nil {
+		_ = c.Flush()
+		if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+			err = ws.Close()
+			if c.OnClose != nil {
+				c.OnClose()
+			}
+		}
 	}
 	return err
 }
 
+// isOpen returns true if the data channel is open, and neither end has closed the connection.
+func (c *SsmDataChannel) isOpen() bool {
+	return c.conn() != nil && atomic.LoadInt32(&c.closed) == 0 && atomic.LoadInt32(&c.peerClosed) == 0
+}
+
+// conn returns the websocket connection of the data channel, or nil if it has not been opened.  The connection is
+// replaced when the session is resumed after the connection drops, so it must be loaded again for each use.
+func (c *SsmDataChannel) conn() *websocket.Conn {
+	ws, _ := c.ws.Load().(*websocket.Conn)
+	return ws
+}
+
+// UnderlyingConn returns the websocket connection used by the data channel, or nil if the channel is not open.
+// This is provided for diagnostic purposes (inspecting the negotiated subprotocol, the underlying network
+// connection, etc.).  Reading from, writing to, or otherwise manipulating the connection directly is unsupported,
+// and will very likely break the data channel.
+func (c *SsmDataChannel) UnderlyingConn() *websocket.Conn {
+	return c.conn()
+}
+
+// LocalAddr returns the local network address of the websocket connection, or nil if the channel is not open.
+func (c *SsmDataChannel) LocalAddr() net.Addr {
+	ws := c.conn()
+	if ws == nil {
+		return nil
+	}
+	return ws.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address of the websocket connection, or nil if the channel is not open.
+func (c *SsmDataChannel) RemoteAddr() net.Addr {
+	ws := c.conn()
+	if ws == nil {
+		return nil
+	}
+	return ws.RemoteAddr()
+}
+
+// ClosedPayload returns the details of the ChannelClosed message sent by the agent, or nil if the channel has
+// not been closed by the agent.
+func (c *SsmDataChannel) ClosedPayload() *ChannelClosedPayload {
+	return c.closedMsg
+}
+
+// SessionInfo returns the session properties negotiated with the agent.  The values are only meaningful for session
+// types which perform a handshake (port forwarding and ssh), after WaitForHandshakeComplete returns.
+func (c *SsmDataChannel) SessionInfo() SessionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.info
+}
+
+// SessionID returns the ID assigned to the session by the StartSession (or ResumeSession) API, or taken from the
+// stream URL by OpenWithStream.  An empty string is returned if the session was started with
+// StartSessionFromDataChannelURL.
+func (c *SsmDataChannel) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+// StreamURL returns the URL of the websocket connection for the session, which changes if the connection is
+// replaced after reconnecting (see MaxReconnects).  The URL is empty if the session was started with
+// StartSessionFromDataChannelURL.
+func (c *SsmDataChannel) StreamURL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streamURL
+}
+
+// Stats returns a snapshot of the message counters for the data channel.
+func (c *SsmDataChannel) Stats() ChannelStats {
+	return ChannelStats{
+		AcksReceived:  atomic.LoadInt64(&c.stats.AcksReceived),
+		UnknownAcks:   atomic.LoadInt64(&c.stats.UnknownAcks),
+		EmptyPayloads: atomic.LoadInt64(&c.stats.EmptyPayloads),
+		BytesSent:     atomic.LoadInt64(&c.stats.BytesSent),
+		BytesReceived: atomic.LoadInt64(&c.stats.BytesReceived),
+		Reconnects:    atomic.LoadInt64(&c.stats.Reconnects),
+	}
+}
+
 // WaitForHandshakeComplete blocks further processing until the required SSM handshake sequence used for
-// port-based clients (including ssh) completes.
+// port-based clients (including ssh) completes.  Shell sessions don't need to call this, the handshake messages
+// are handled by HandleMsg as they are received along with the shell output.  Output received before the
+// handshake completes (from a server which speaks first) is kept, and must be retrieved with PendingOutput
+// before reading from the data channel with Read and HandleMsg.  WriteTo writes it before any other output.
 func (c *SsmDataChannel) WaitForHandshakeComplete() error {
 	buf := make([]byte, 4096)
 
 	for {
 		select {
 		case <-c.handshakeCh:
-			// make stream unbuffered
+			// keep any queued output which is in sequence, then make stream unbuffered
+			if payload, err := c.processInboundQueue(); err == nil {
+				c.keepHandshakeOutput(payload)
+			}
 			c.inMsgBuf = nil
 			c.outMsgBuf = nil
 			c.handshakeCh = nil
@@ -89,21 +351,56 @@ func (c *SsmDataChannel) WaitForHandshakeComplete() error {
 				return err
 			}
 
-			if _, err = c.HandleMsg(buf[:n]); err != nil {
+			payload, err := c.HandleMsg(buf[:n])
+			if err != nil {
 				return err
 			}
+			c.keepHandshakeOutput(payload)
 		}
 	}
 }
 
+// keepHandshakeOutput saves output received while waiting for the handshake to complete.  The payload may
+// reference the read buffer, so it is copied.
+func (c *SsmDataChannel) keepHandshakeOutput(payload []byte) {
+	if len(payload) < 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handshakeOutput = append(c.handshakeOutput, payload...)
+}
+
+// PendingOutput returns the output received by WaitForHandshakeComplete before the handshake completed, and clears
+// it, so it is only returned once.  Callers reading the data channel with Read and HandleMsg should deliver this to
+// the consumer before any other output.
+func (c *SsmDataChannel) PendingOutput() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := c.handshakeOutput
+	c.handshakeOutput = nil
+	return out
+}
+
 // Read will get a single message from the websocket connection. The unprocessed message is copied to the
-// requested []byte (which should be sized to handle at least 1536 bytes).
+// requested []byte (which should be sized to handle at least 1536 bytes).  Read blocks until a message is
+// received, and never returns 0 bytes without an error, so callers can loop on Read without spinning.
 func (c *SsmDataChannel) Read(data []byte) (int, error) {
-	_, msg, err := c.ws.ReadMessage()
+	_, msg, err := c.conn().ReadMessage()
 	n := copy(data[:len(msg)], msg)
 
 	if err != nil {
+		if c.canReconnect(err) {
+			logger().Warnf("websocket connection lost, reconnecting: %v", err)
+			if rerr := c.reconnect(); rerr == nil {
+				return c.Read(data)
+			}
+		}
+
 		// gorilla code states this is uber-fatal, and we just need to bail out
+		atomic.StoreInt32(&c.peerClosed, 1)
 		if websocket.IsCloseError(err, 1000, 1001, 1006) {
 			err = io.EOF
 		}
@@ -114,6 +411,7 @@ func (c *SsmDataChannel) Read(data []byte) (int, error) {
 		return n, errors.New("invalid message received, too short")
 	}
 
+	_ = c.extendReadDeadline()
 	return n, nil
 }
 
@@ -123,10 +421,18 @@ func (c *SsmDataChannel) WriteTo(w io.Writer) (n int64, err error) {
 	var nr, nw int
 	var payload []byte
 
+	if early := c.PendingOutput(); len(early) > 0 {
+		nw, err = w.Write(early)
+		n += int64(nw)
+		if err != nil {
+			return n, err
+		}
+	}
+
 	for {
 		nr, err = c.Read(buf)
 		if err != nil {
-			log.Printf("WriteTo read error: %v", err)
+			logger().Debugf("WriteTo read error: %v", err)
 			return n, err
 		}
 
@@ -137,7 +443,7 @@ func (c *SsmDataChannel) WriteTo(w io.Writer) (n int64, err error) {
 				if errors.Is(err, io.EOF) {
 					isEOF = true
 				} else {
-					log.Printf("WriteTo HandleMsg error: %v", err)
+					logger().Debugf("WriteTo HandleMsg error: %v", err)
 					return n, err
 				}
 			}
@@ -146,7 +452,7 @@ func (c *SsmDataChannel) WriteTo(w io.Writer) (n int64, err error) {
 				nw, err = w.Write(payload)
 				n += int64(nw)
 				if err != nil {
-					log.Printf("WriteTo write error: %v", err)
+					logger().Debugf("WriteTo write error: %v", err)
 					return n, err
 				}
 			}
@@ -171,13 +477,13 @@ func (c *SsmDataChannel) ReadFrom(r io.Reader) (n int64, err error) {
 				// the contract of ReaderFrom states that io.EOF should not be returned, just
 				// exit the loop and return no error to indicate we are done
 				err = nil
-				log.Print("ReadFrom reader is closed")
+				logger().Debugf("ReadFrom reader is closed")
 			}
 			break
 		}
 
 		if _, err = c.Write(buf[:nr]); err != nil {
-			log.Printf("ReadFrom write error: %v", err)
+			logger().Debugf("ReadFrom write error: %v", err)
 			break
 		}
 	}
@@ -185,21 +491,90 @@ func (c *SsmDataChannel) ReadFrom(r io.Reader) (n int64, err error) {
 }
 
 // Write sends an input stream data message type with the provided payload bytes as the message payload.
+// If WriteCoalesceDelay is set, the payload may be buffered and sent along with data from subsequent calls.
 func (c *SsmDataChannel) Write(payload []byte) (int, error) {
-	msg := NewAgentMessage()
-	msg.MessageType = InputStreamData
-	msg.Flags = Data
-	msg.PayloadType = Output
-	msg.Payload = payload
-	msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
+	if c.WriteCoalesceDelay > 0 {
+		return c.coalesceWrite(payload)
+	}
+	return c.writePayload(payload)
+}
+
+// WriteMessage sends the payload as exactly 1 input stream data message, for callers which need control over how
+// the data is framed, like sending a complete request to a message-oriented remote program.  Any data buffered by
+// write coalescing is sent first.  ErrPayloadTooLarge is returned if the payload is larger than MaxPayloadSize
+// (or DefaultMaxPayloadSize, if not set).  The agent passes the payload to the remote program as a single write,
+// but the program may still read it in smaller pieces.
+func (c *SsmDataChannel) WriteMessage(payload []byte) (int, error) {
+	if len(payload) > c.maxPayloadSize() {
+		return 0, ErrPayloadTooLarge
+	}
+
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+	return c.writePayload(payload)
+}
 
-	return c.WriteMsg(msg)
+// maxPayloadSize returns MaxPayloadSize, or DefaultMaxPayloadSize if not set.
+func (c *SsmDataChannel) maxPayloadSize() int {
+	if c.MaxPayloadSize < 1 {
+		return DefaultMaxPayloadSize
+	}
+	return c.MaxPayloadSize
+}
+
+// writePayload sends the payload as a sequence of messages no larger than MaxPayloadSize, returning the number of
+// payload bytes sent.
+func (c *SsmDataChannel) writePayload(payload []byte) (int, error) {
+	size := c.maxPayloadSize()
+
+	var n int
+	for {
+		chunk := payload
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		payload = payload[len(chunk):]
+
+		data := chunk
+		if e := c.getEncrypter(); e != nil {
+			var err error
+			if data, err = e.encrypt(chunk); err != nil {
+				return n, err
+			}
+		}
+
+		msg := NewAgentMessage()
+		msg.MessageType = InputStreamData
+		msg.Flags = Data
+		msg.PayloadType = Output
+		msg.Payload = data
+		msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
+
+		if _, err := c.WriteMsg(msg); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		atomic.AddInt64(&c.stats.BytesSent, int64(len(chunk)))
+		if len(payload) < 1 {
+			return n, nil
+		}
+	}
 }
 
 // WriteMsg is the underlying method which marshals AgentMessage types and sends them to the AWS service.
 // This is provided as a convenience so that messages types not already handled can be sent. If the message
 // SequenceNumber field is less than 0, it will be automatically incremented using the internal counter.
+//
+// Until the handshake completes, messages are held in the outbound buffer (so they can be resent) until the agent
+// acknowledges them.  Acknowledge and HandshakeResponse messages are never buffered, since the agent does not
+// acknowledge an Acknowledge, and the handshake response is only sent in reply to the agent's request.
 func (c *SsmDataChannel) WriteMsg(msg *AgentMessage) (int, error) {
+	if !c.isOpen() {
+		return 0, ErrNotOpen
+	}
+
 	if !c.synSent {
 		atomic.StoreInt64(&c.seqNum, 0)
 		msg.Flags = Syn
@@ -224,18 +599,71 @@ func (c *SsmDataChannel) WriteMsg(msg *AgentMessage) (int, error) {
 	}
 
 	if !c.pausePub {
-		return int(msg.payloadLength), c.ws.WriteMessage(websocket.BinaryMessage, data)
+		c.debugEvent(EventMessageSent, msg)
+		return int(msg.payloadLength), c.writeMessage(data)
 	}
 	return int(msg.payloadLength), err
 }
 
-//nolint:gocognit,gocyclo
+// writeMessage sends the data as a binary websocket message, applying the WriteTimeout if set.  The caller must
+// hold c.mu.
+func (c *SsmDataChannel) writeMessage(data []byte) error {
+	ws := c.conn()
+	if c.WriteTimeout > 0 {
+		if err := ws.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	err := ws.WriteMessage(websocket.BinaryMessage, data)
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// the websocket is unusable after a failed write, close the network connection (not c.Close(), which
+		// needs c.mu to flush buffered data) so a blocked Read() returns
+		atomic.StoreInt32(&c.peerClosed, 1)
+		_ = ws.UnderlyingConn().Close()
+	}
+	return err
+}
+
+// debugEvent sends a DebugEvent for the message to the DebugEvents channel, if set.
+func (c *SsmDataChannel) debugEvent(t DebugEventType, msg *AgentMessage) {
+	if c.DebugEvents == nil {
+		return
+	}
+
+	e := DebugEvent{
+		Type:           t,
+		MessageType:    msg.MessageType,
+		PayloadType:    msg.PayloadType,
+		SequenceNumber: msg.SequenceNumber,
+		Time:           time.Now(),
+	}
+
+	select {
+	case c.DebugEvents <- e:
+	default:
+	}
+}
+
 // HandleMsg takes the unprocessed message bytes from the websocket connection (a la Read()), unmarshals the data
 // and takes the appropriate action based on the message type.  Messages which have an actionable payload (output
 // payload types, and channel closed payloads) will have that data returned.  Errors will be returned for unknown/
 // unhandled message or payload types.  A ChannelClosed message type will return an io.EOF error to indicate that
 // this SSM data channel is shutting down and should no longer be used.
 func (c *SsmDataChannel) HandleMsg(data []byte) ([]byte, error) {
+	payload, err := c.handleMsg(data)
+	if len(payload) < 1 && err == nil {
+		atomic.AddInt64(&c.stats.EmptyPayloads, 1)
+	} else if err == nil {
+		atomic.AddInt64(&c.stats.BytesReceived, int64(len(payload)))
+	}
+	return payload, err
+}
+
+//nolint:gocognit,gocyclo
+func (c *SsmDataChannel) handleMsg(data []byte) ([]byte, error) {
 	m := new(AgentMessage)
 	if err := m.UnmarshalBinary(data); err != nil {
 		// validation error
@@ -245,20 +673,37 @@ func (c *SsmDataChannel) HandleMsg(data []byte) ([]byte, error) {
 	//nolint:exhaustive // we'll add more as we find them
 	switch m.MessageType {
 	case Acknowledge:
-		if c.outMsgBuf != nil {
-			c.outMsgBuf.Remove(m.SequenceNumber)
-		}
+		c.processAcknowledge(m)
 	case PausePublication:
+		c.debugEvent(EventPausePublication, m)
 		c.pausePub = true
 	case StartPublication:
+		c.debugEvent(EventStartPublication, m)
 		c.pausePub = false
 	case OutputStreamData:
 		switch m.PayloadType {
-		case Output:
+		case Output, Error:
+			if c.inMsgBuf == nil {
+				// messages may be resent by the agent after reconnecting, acknowledge and discard duplicates
+				if m.SequenceNumber < atomic.LoadInt64(&c.inSeqNum) {
+					_ = c.sendAcknowledgeMessage(m)
+					return nil, nil
+				}
+				atomic.StoreInt64(&c.inSeqNum, m.SequenceNumber+1)
+			}
+
+			if e := c.getEncrypter(); e != nil && m.PayloadType == Output {
+				payload, err := e.decrypt(m.Payload)
+				if err != nil {
+					return nil, fmt.Errorf("error decrypting message payload: %w", err)
+				}
+				m.Payload = payload
+			}
+
 			// unbuffered - return payload directly
 			if c.inMsgBuf == nil {
 				_ = c.sendAcknowledgeMessage(m) // todo - handle error?
-				return m.Payload, nil
+				return c.outputPayload(m), nil
 			}
 
 			// duplicate message - discard
@@ -271,16 +716,25 @@ func (c *SsmDataChannel) HandleMsg(data []byte) ([]byte, error) {
 				return nil, err
 			}
 		case HandshakeRequest:
+			c.debugEvent(EventHandshakeRequest, m)
 			// port forwarding session setup, we'll consider a handshake failure fatal
 			if err := c.processHandshakeRequest(m); err != nil {
 				return nil, err
 			}
+		case EncChallengeRequest:
+			if err := c.processEncryptionChallenge(m); err != nil {
+				return nil, err
+			}
 		case HandshakeComplete:
+			c.debugEvent(EventHandshakeDone, m)
+			c.processHandshakeComplete(m)
 			if c.handshakeCh != nil {
 				close(c.handshakeCh)
 			}
 		default:
-			return nil, fmt.Errorf("UNKNOWN INCOMING MSG PAYLOAD: %s\n%s", m, m.Payload)
+			if err := c.unknownMessage(fmt.Errorf("UNKNOWN INCOMING MSG PAYLOAD: %s\n%s", m, m.Payload)); err != nil {
+				return nil, err
+			}
 		}
 	case ChannelClosed:
 		payload := new(ChannelClosedPayload)
@@ -288,13 +742,29 @@ func (c *SsmDataChannel) HandleMsg(data []byte) ([]byte, error) {
 			return nil, err
 		}
 
+		c.closedMsg = payload
+		atomic.StoreInt32(&c.peerClosed, 1)
+		c.terminated(-1)
+		if c.OnChannelClosed != nil {
+			c.OnChannelClosed(payload)
+		}
+
 		var output []byte
 		if len(payload.Output) > 0 {
 			output = []byte(payload.Output)
 		}
 		return output, io.EOF
+	case TaskReply, TaskComplete:
+		c.debugEvent(EventTaskMessage, m)
+		if c.OnTaskMessage != nil {
+			c.OnTaskMessage(m)
+		} else {
+			logger().Debugf("ignoring %s message", m.MessageType)
+		}
 	default:
-		return nil, fmt.Errorf("UNKNOWN MESSAGE TYPE: %+v", m)
+		if err := c.unknownMessage(fmt.Errorf("UNKNOWN MESSAGE TYPE: %+v", m)); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := c.sendAcknowledgeMessage(m); err != nil {
@@ -305,6 +775,19 @@ func (c *SsmDataChannel) HandleMsg(data []byte) ([]byte, error) {
 	return c.processInboundQueue()
 }
 
+// unknownMessage applies the UnknownMessagePolicy, returning err if the message should fail HandleMsg, or nil if
+// the message should be acknowledged and discarded.
+func (c *SsmDataChannel) unknownMessage(err error) error {
+	switch c.UnknownMessagePolicy {
+	case UnknownMessageError:
+		return err
+	case UnknownMessageIgnore:
+	default:
+		logger().Warnf("discarding message: %v", err)
+	}
+	return nil
+}
+
 // SetTerminalSize sends a message to the SSM service which indicates the size to use for the remote terminal
 // when using a shell session client.
 func (c *SsmDataChannel) SetTerminalSize(rows, cols uint32) error {
@@ -340,38 +823,186 @@ func (c *SsmDataChannel) SetTerminalSize(rows, cols uint32) error {
 
 // TerminateSession sends the TerminateSession message to the AWS service to indicate that the port forwarding
 // session is ending, so it can clean up any connections used to communicate with the EC2 instance agent.
+// ErrNotOpen is returned if the data channel is already closed.  If TerminateTimeout is set, this waits for the
+// agent to acknowledge the message, the same as TerminateSessionAndWait.
 func (c *SsmDataChannel) TerminateSession() error {
-	msg := NewAgentMessage()
-	msg.MessageType = InputStreamData
-	msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
-	msg.Flags = Fin
-	msg.PayloadType = Flag
+	if c.TerminateTimeout > 0 {
+		return c.TerminateSessionAndWait(c.TerminateTimeout)
+	}
+	return c.SendControlFlag(TerminateSession)
+}
 
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(TerminateSession))
-	msg.Payload = buf
+// TerminateSessionAndWait sends the TerminateSession message the same as TerminateSession, then waits up to the
+// timeout for the agent to acknowledge the message (or close the channel), so that the session is known to be
+// ended before the data channel is closed.  Acknowledgements are processed by HandleMsg, so another goroutine must
+// be reading from the data channel while this method waits.  ErrTerminateTimeout is returned if the timeout expires.
+func (c *SsmDataChannel) TerminateSessionAndWait(timeout time.Duration) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
 
-	_, err := c.WriteMsg(msg)
-	return err
+	var msg *AgentMessage
+
+	// register before sending, so an acknowledgement received before WriteMsg returns isn't missed.  If another
+	// goroutine is already waiting, wait for the same message instead of sending another.
+	c.mu.Lock()
+	ch := c.termCh
+	if ch == nil {
+		msg = controlFlagMessage(TerminateSession)
+		msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
+
+		ch = make(chan struct{})
+		c.termSeq = msg.SequenceNumber
+		c.termCh = ch
+		defer c.clearTerminate(ch)
+	}
+	c.mu.Unlock()
+
+	if msg != nil {
+		if _, err := c.WriteMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-ch:
+		return nil
+	case <-t.C:
+		return ErrTerminateTimeout
+	}
+}
+
+// clearTerminate removes the registration made by TerminateSessionAndWait, if it hasn't been replaced.
+func (c *SsmDataChannel) clearTerminate(ch chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.termCh == ch {
+		c.termCh = nil
+	}
+}
+
+// terminated releases TerminateSessionAndWait if seq is the sequence number of the TerminateSession message being
+// waited on, or is negative.
+func (c *SsmDataChannel) terminated(seq int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.termCh != nil && (seq < 0 || seq == c.termSeq) {
+		close(c.termCh)
+		c.termCh = nil
+	}
 }
 
 // DisconnectPort sends the DisconnectToPort message to the AWS service to indicate that a non-muxing stream is
 // shutting down and any connection used to communicate with the EC2 instance agent can be cleaned up.  Unlike
 // the TerminateSession action, the websocket connection is still capable of initiating a new port forwarding
-// stream to the agent without needing to restart the program.
+// stream to the agent without needing to restart the program.  ErrNotOpen is returned if the data channel is
+// already closed.
 func (c *SsmDataChannel) DisconnectPort() error {
+	return c.SendControlFlag(DisconnectToPort)
+}
+
+// SendControlFlag sends a Flag payload message with the provided flag value to the agent, after sending any
+// data buffered by write coalescing.  The TerminateSession flag is sent as the final (Fin) message of the stream,
+// all other flags are sent as regular data messages.  Most callers should use TerminateSession() or
+// DisconnectPort(), this method is meant for flags not covered by those, and for testing.
+func (c *SsmDataChannel) SendControlFlag(flag PayloadTypeFlag) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	msg := controlFlagMessage(flag)
+	msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
+
+	_, err := c.WriteMsg(msg)
+	return err
+}
+
+// controlFlagMessage returns the message used to send the flag to the agent, without a sequence number.
+func controlFlagMessage(flag PayloadTypeFlag) *AgentMessage {
 	msg := NewAgentMessage()
 	msg.MessageType = InputStreamData
-	msg.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
 	msg.Flags = Data
 	msg.PayloadType = Flag
 
+	if flag == TerminateSession {
+		msg.Flags = Fin
+	}
+
 	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, uint32(DisconnectToPort))
+	binary.BigEndian.PutUint32(buf, uint32(flag))
 	msg.Payload = buf
+	return msg
+}
 
-	_, err := c.WriteMsg(msg)
-	return err
+// WaitForAcks sends any data buffered by write coalescing, then waits up to the timeout for the agent to acknowledge
+// all the messages sent to it, so that input isn't lost if the session is closed immediately afterwards.
+// Acknowledgements are processed by HandleMsg, so another goroutine must be reading from the data channel while this
+// method waits.  Delivery can only be confirmed for shell sessions, after the handshake of port forwarding and ssh
+// sessions the messages are no longer buffered, and this returns as soon as the coalesced data is sent.  If the
+// channel is closed with messages still waiting for acknowledgement, ErrNotOpen is returned, and ErrAckTimeout is
+// returned if the timeout expires.
+func (c *SsmDataChannel) WaitForAcks(timeout time.Duration) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		buf := c.outMsgBuf
+		if buf == nil || buf.Len() < 1 {
+			return nil
+		}
+
+		if !c.isOpen() {
+			return ErrNotOpen
+		}
+
+		if time.Now().After(deadline) {
+			return ErrAckTimeout
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// processAcknowledge removes the acknowledged message from the outbound buffer, so it will not be resent.  An
+// acknowledgement for a message which isn't buffered is not an error (it may be a duplicate ack, or an ack for a
+// message which was never buffered, like the handshake response), but is counted in the channel stats to help
+// diagnose retransmission issues.  After the handshake completes, the channel is unbuffered and acks are ignored.
+func (c *SsmDataChannel) processAcknowledge(m *AgentMessage) {
+	atomic.AddInt64(&c.stats.AcksReceived, 1)
+	c.debugEvent(EventMessageAcked, m)
+	c.terminated(m.SequenceNumber)
+
+	if c.outMsgBuf == nil {
+		return
+	}
+
+	if c.outMsgBuf.Get(m.SequenceNumber) == nil {
+		atomic.AddInt64(&c.stats.UnknownAcks, 1)
+		return
+	}
+	c.outMsgBuf.Remove(m.SequenceNumber)
+}
+
+// outputPayload returns the data from an OutputStreamData message which should be delivered to the reader.  Error
+// payloads are passed to the OnAgentError function (or logged) instead of being treated as session output, but still
+// take part in the message sequencing.
+func (c *SsmDataChannel) outputPayload(m *AgentMessage) []byte {
+	if m.PayloadType != Error {
+		return m.Payload
+	}
+
+	if c.OnAgentError != nil {
+		c.OnAgentError(m.Payload)
+	} else {
+		logger().Warnf("agent reported error: %s", m.Payload)
+	}
+	return nil
 }
 
 func (c *SsmDataChannel) processInboundQueue() ([]byte, error) {
@@ -386,7 +1017,7 @@ func (c *SsmDataChannel) processInboundQueue() ([]byte, error) {
 		if msg := c.inMsgBuf.Get(c.inSeqNum); msg != nil {
 			atomic.AddInt64(&c.inSeqNum, 1)
 
-			if _, err = data.Write(msg.Payload); err != nil {
+			if _, err = data.Write(c.outputPayload(msg)); err != nil {
 				break
 			}
 
@@ -411,6 +1042,7 @@ func (c *SsmDataChannel) processOutboundQueue() {
 		}
 
 		for m := c.outMsgBuf.Next(); m != nil; m = c.outMsgBuf.Next() {
+			c.debugEvent(EventRetransmit, m)
 			if _, err := c.WriteMsg(m); err != nil {
 				// todo - handle error?
 			}
@@ -419,7 +1051,9 @@ func (c *SsmDataChannel) processOutboundQueue() {
 }
 
 // sendAcknowledgeMessage sends the Acknowledge message type for each incoming message read from
-// the web socket connection, which is required as part of the SSM session protocol.
+// the web socket connection, which is required as part of the SSM session protocol.  The agent only removes a
+// message from its resend buffer when that exact sequence number is acknowledged, so acknowledgements can't be
+// batched or made cumulative.
 func (c *SsmDataChannel) sendAcknowledgeMessage(msg *AgentMessage) error {
 	ack := map[string]interface{}{
 		"AcknowledgedMessageType":           msg.MessageType,
@@ -452,8 +1086,20 @@ func (c *SsmDataChannel) processHandshakeRequest(msg *AgentMessage) error {
 	if err := json.Unmarshal(msg.Payload, req); err != nil {
 		return err
 	}
+	c.updateSessionInfo(msg, req)
+
+	res := buildHandshakeResponse(c.clientVersion(), req.RequestedClientActions)
+	for i, a := range req.RequestedClientActions {
+		if a.ActionType == KMSEncryption {
+			var err error
+			if res.ProcessedClientActions[i], err = c.processKMSEncryption(a); err != nil {
+				logger().Errorf("session encryption failed: %v", err)
+				res.Errors = append(res.Errors, err.Error())
+			}
+		}
+	}
 
-	payload, err := json.Marshal(buildHandshakeResponse(req.RequestedClientActions))
+	payload, err := json.Marshal(res)
 	if err != nil {
 		return err
 	}
@@ -469,50 +1115,184 @@ func (c *SsmDataChannel) processHandshakeRequest(msg *AgentMessage) error {
 	return err
 }
 
+// updateSessionInfo records the session properties from the agent's handshake request.
+func (c *SsmDataChannel) updateSessionInfo(msg *AgentMessage, req *HandshakeRequestPayload) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.info.AgentVersion = req.AgentVersion
+	c.info.ClientVersion = c.clientVersion()
+	c.info.SchemaVersion = msg.schemaVersion
+	c.info.Multiplexing = versionAtLeast(c.info.ClientVersion, muxClientVersion) &&
+		versionAtLeast(req.AgentVersion, muxAgentVersion)
+
+	for _, a := range req.RequestedClientActions {
+		switch a.ActionType {
+		case SessionType:
+			st := new(SessionTypeRequest)
+			if err := convertActionParameters(a.ActionParameters, st); err == nil {
+				c.info.SessionType = st.SessionType
+			}
+		case KMSEncryption:
+			kr := new(KMSEncryptionRequest)
+			if err := convertActionParameters(a.ActionParameters, kr); err == nil {
+				c.info.KMSKeyID = kr.KMSKeyID
+			}
+		}
+	}
+}
+
+// clientVersion returns the version reported to the agent in the handshake response.
+func (c *SsmDataChannel) clientVersion() string {
+	if c.Multiplex {
+		return muxClientVersion
+	}
+	return clientVersion
+}
+
+// processHandshakeComplete records the session properties from the agent's handshake complete message.
+func (c *SsmDataChannel) processHandshakeComplete(msg *AgentMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.info.HandshakeComplete = true
+
+	// the payload is informational only, so a malformed payload isn't considered fatal
+	payload := new(HandshakeCompletePayload)
+	if err := json.Unmarshal(msg.Payload, payload); err == nil {
+		c.info.HandshakeTime = payload.HandshakeTimeToComplete
+		c.info.CustomerMessage = payload.CustomerMessage
+	}
+}
+
 func (c *SsmDataChannel) startSession(cfg aws.Config, in *ssm.StartSessionInput) error {
+	cfg = withDefaultUserAgent(cfg)
 	out, err := ssm.NewFromConfig(cfg).StartSession(context.Background(), in)
 	if err != nil {
 		return err
 	}
+
+	c.awsCfg = &cfg
+	c.sessionID = aws.ToString(out.SessionId)
+	c.streamURL = aws.ToString(out.StreamUrl)
+	c.target = aws.ToString(in.Target)
+	if c.OnSessionStart != nil {
+		c.OnSessionStart(c.sessionID)
+	}
+
+	if len(c.BookmarkPath) > 0 {
+		if err = newBookmark(out, in).write(c.BookmarkPath); err != nil {
+			return err
+		}
+	}
 	return c.StartSessionFromDataChannelURL(*out.StreamUrl, *out.TokenValue)
 }
 
 func (c *SsmDataChannel) StartSessionFromDataChannelURL(url string, token string) error {
-	ws, _, err := websocket.DefaultDialer.Dial(url, http.Header{}) //nolint:bodyclose
+	return c.connect(url, token, true)
+}
+
+// connect opens the data channel on the websocket at the provided url, calling PreHandshake if preHandshake is true
+// (which it isn't when resuming a session).
+func (c *SsmDataChannel) connect(url, token string, preHandshake bool) error {
+	ws, err := dialWebsocket(context.Background(), c.Dialer, url)
 	if err != nil {
 		return err
 	}
-	c.ws = ws
+	c.ws.Store(ws)
 
 	if err = c.openDataChannel(token); err != nil {
 		_ = c.Close()
 		return err
 	}
 
+	if preHandshake && c.PreHandshake != nil {
+		if err = c.PreHandshake(c); err != nil {
+			_ = c.Close()
+			return err
+		}
+	}
+
+	c.startKeepAlive()
 	return nil
 }
 
+// defaultDialer is used to connect to the websocket if SsmDataChannel.Dialer is not set.  It is the same as
+// websocket.DefaultDialer, but defined here so the use of proxy environment variables doesn't depend on it.
+var defaultDialer = &websocket.Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// dialWebsocket connects to the websocket at the provided url, retrying transient failures with an increasing
+// delay between attempts.  This is separate from any retry done by the AWS SDK for the StartSession API call.
+func dialWebsocket(ctx context.Context, dialer *websocket.Dialer, url string) (*websocket.Conn, error) {
+	if dialer == nil {
+		dialer = defaultDialer
+	}
+
+	var ws *websocket.Conn
+	var res *http.Response
+	var err error
+
+	for i := 0; i < dialAttempts; i++ {
+		if i > 0 {
+			logger().Warnf("websocket dial failed, retrying: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(dialBackoff << (i - 1)):
+			}
+		}
+
+		ws, res, err = dialer.DialContext(ctx, url, http.Header{}) //nolint:bodyclose
+		if err == nil || !isTransientDialError(err, res) {
+			break
+		}
+	}
+
+	return ws, err
+}
+
+// isTransientDialError checks if a websocket dial error is likely to succeed if retried.  Network level errors
+// (timeouts, connection resets, EOF during the TLS handshake) and server-side HTTP errors are considered transient,
+// anything else (like a 403 response due to an expired token) is not.
+func isTransientDialError(err error, res *http.Response) bool {
+	if res != nil {
+		return res.StatusCode >= http.StatusInternalServerError
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
 func (c *SsmDataChannel) openDataChannel(token string) error {
-	openDataChanInput := map[string]string{
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn().WriteJSON(openDataChannelInput(token))
+}
+
+// openDataChannelInput returns the message which must be the first message sent over the websocket connection.
+func openDataChannelInput(token string) map[string]string {
+	return map[string]string{
 		"MessageSchemaVersion": "1.0",
 		"RequestId":            uuid.New().String(),
 		"TokenValue":           token,
 	}
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.ws.WriteJSON(openDataChanInput)
 }
 
 // the only requirement of the handshake response is that we include an element in ProcessedClientActions
-// for each element of RequestedClientActions (there's only 2 types, and port forwarding only uses the
-// SessionType action type, so there should only be 1 element), and the ActionStatus is Success.  Any
-// non-success is considered a failure in the receiving agent.
-func buildHandshakeResponse(actions []RequestedClientAction) *HandshakeResponsePayload {
+// for each element of RequestedClientActions (there's only 2 types, the SessionType action is handled here,
+// and the KMSEncryption action, which needs a KMS API call, is handled by processKMSEncryption), and the
+// ActionStatus is Success.  Any non-success is considered a failure in the receiving agent.
+func buildHandshakeResponse(version string, actions []RequestedClientAction) *HandshakeResponsePayload {
 	res := HandshakeResponsePayload{
-		// seems this can be whatever we need it to be, however certain features may only be available at
-		// certain client versions (must report at least version 1.1.70 to do stream muxing)
-		ClientVersion:          "0.0.1",
+		ClientVersion:          version,
 		ProcessedClientActions: make([]ProcessedClientAction, len(actions)),
 	}
 
@@ -529,3 +1309,35 @@ func buildHandshakeResponse(actions []RequestedClientAction) *HandshakeResponseP
 
 	return &res
 }
+
+// convertActionParameters converts the generic ActionParameters of a RequestedClientAction (which is decoded as a
+// map by encoding/json) to the specific type for the action.
+func convertActionParameters(params interface{}, v interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// versionAtLeast compares the dot-separated numeric version strings, returning true if version is the same as,
+// or newer than, minVersion.  Any non-numeric version component is treated as 0.
+func versionAtLeast(version, minVersion string) bool {
+	v := strings.Split(version, ".")
+	m := strings.Split(minVersion, ".")
+
+	for i := 0; i < len(v) || i < len(m); i++ {
+		var a, b int
+		if i < len(v) {
+			a, _ = strconv.Atoi(v[i])
+		}
+		if i < len(m) {
+			b, _ = strconv.Atoi(m[i])
+		}
+
+		if a != b {
+			return a > b
+		}
+	}
+	return true
+}
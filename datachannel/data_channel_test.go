@@ -0,0 +1,438 @@
+package datachannel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// agentRetransmitTimeout is the default time the agent waits for an acknowledgement before resending a message.
+const agentRetransmitTimeout = 200 * time.Millisecond
+
+// testAgent is the remote end of a data channel created by newTestChannel.  It receives the messages sent by the
+// data channel, and counts the websocket frames they were sent in.
+type testAgent struct {
+	t      *testing.T
+	msgs   chan *AgentMessage
+	frames int32
+}
+
+// newTestChannel returns an SsmDataChannel connected to a websocket server which decodes each message sent by the
+// data channel and delivers it to the testAgent.  The data channel is closed when the test ends.
+func newTestChannel(t *testing.T, c *SsmDataChannel) (*SsmDataChannel, *testAgent) {
+	t.Helper()
+
+	agent := &testAgent{t: t, msgs: make(chan *AgentMessage, 1024)}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up := websocket.Upgrader{}
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		// the 1st message opens the data channel, and is not an AgentMessage
+		if _, _, err = ws.ReadMessage(); err != nil {
+			t.Errorf("error reading open data channel message: %v", err)
+			return
+		}
+
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&agent.frames, 1)
+
+			m := new(AgentMessage)
+			if err = m.UnmarshalBinary(data); err != nil {
+				t.Errorf("invalid message from data channel: %v", err)
+				return
+			}
+			agent.msgs <- m
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c.KeepAliveInterval = -1
+	if err := c.StartSessionFromDataChannelURL("ws"+strings.TrimPrefix(srv.URL, "http"), "token"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	return c, agent
+}
+
+// next returns the next message sent by the data channel, failing the test if none is received within the timeout.
+func (a *testAgent) next(timeout time.Duration) *AgentMessage {
+	a.t.Helper()
+
+	select {
+	case m := <-a.msgs:
+		return m
+	case <-time.After(timeout):
+		a.t.Fatal("timed out waiting for message from data channel")
+		return nil
+	}
+}
+
+func TestSsmDataChannel_WriteChunking(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxSize  int
+		size     int
+		readFrom bool
+		want     int
+	}{
+		{name: "smaller than max", maxSize: 16, size: 10, want: 1},
+		{name: "exactly max", maxSize: 16, size: 16, want: 1},
+		{name: "multiple of max", maxSize: 16, size: 64, want: 4},
+		{name: "partial last chunk", maxSize: 16, size: 70, want: 5},
+		{name: "default max", size: 3*DefaultMaxPayloadSize + 1, want: 4},
+		{name: "ReadFrom", maxSize: 100, size: 1000, readFrom: true, want: 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, agent := newTestChannel(t, &SsmDataChannel{MaxPayloadSize: tc.maxSize})
+
+			data := bytes.Repeat([]byte("0123456789abcdef"), tc.size/16+1)[:tc.size]
+
+			var err error
+			if tc.readFrom {
+				_, err = c.ReadFrom(bytes.NewReader(data))
+			} else {
+				var n int
+				n, err = c.Write(data)
+				if n != len(data) {
+					t.Errorf("wrote %d bytes, want %d", n, len(data))
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			limit := tc.maxSize
+			if limit < 1 {
+				limit = DefaultMaxPayloadSize
+			}
+
+			got := new(bytes.Buffer)
+			var lastSeq int64 = -1
+			for i := 0; i < tc.want; i++ {
+				m := agent.next(time.Second)
+				if m.MessageType != InputStreamData || m.PayloadType != Output {
+					t.Fatalf("message %d is %s/%d, want %s/%d", i, m.MessageType, m.PayloadType, InputStreamData, Output)
+				}
+
+				if len(m.Payload) > limit {
+					t.Errorf("message %d payload is %d bytes, larger than %d", i, len(m.Payload), limit)
+				}
+
+				if i > 0 && m.SequenceNumber != lastSeq+1 {
+					t.Errorf("message %d has sequence number %d, want %d", i, m.SequenceNumber, lastSeq+1)
+				}
+				lastSeq = m.SequenceNumber
+				got.Write(m.Payload)
+			}
+
+			if !bytes.Equal(got.Bytes(), data) {
+				t.Error("reassembled payload does not match the data written")
+			}
+
+			select {
+			case m := <-agent.msgs:
+				t.Errorf("unexpected extra message: %s", m)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestSsmDataChannel_WriteMessageTooLarge(t *testing.T) {
+	c, agent := newTestChannel(t, &SsmDataChannel{MaxPayloadSize: 8})
+
+	if _, err := c.WriteMessage(make([]byte, 9)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("got error %v, want %v", err, ErrPayloadTooLarge)
+	}
+
+	if _, err := c.WriteMessage(make([]byte, 8)); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := agent.next(time.Second); len(m.Payload) != 8 {
+		t.Errorf("payload is %d bytes, want 8", len(m.Payload))
+	}
+}
+
+func TestSsmDataChannel_Acknowledge(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []int64 // sequence numbers of the messages sent by the agent, repeats are retransmissions
+	}{
+		{name: "in order", seqs: []int64{0, 1, 2}},
+		{name: "retransmit", seqs: []int64{0, 1, 1, 2}},
+		{name: "out of order", seqs: []int64{1, 0, 2}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, agent := newTestChannel(t, new(SsmDataChannel))
+
+			msgs := make(map[int64]*AgentMessage)
+			for _, seq := range tc.seqs {
+				m, ok := msgs[seq]
+				if !ok {
+					m = outputMessage(seq, []byte(fmt.Sprintf("message %d", seq)))
+					msgs[seq] = m
+				}
+
+				data, err := m.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				sent := time.Now()
+				if _, err = c.HandleMsg(data); err != nil {
+					t.Fatal(err)
+				}
+
+				// the agent only removes a message from its resend buffer when that exact message is acknowledged
+				ack := agent.next(agentRetransmitTimeout - time.Since(sent))
+				if ack.MessageType != Acknowledge {
+					t.Fatalf("got %s message, want %s", ack.MessageType, Acknowledge)
+				}
+
+				content := new(struct {
+					AcknowledgedMessageType           MessageType
+					AcknowledgedMessageId             string //nolint:revive,stylecheck // the name used by the agent
+					AcknowledgedMessageSequenceNumber int64
+				})
+				if err = json.Unmarshal(ack.Payload, content); err != nil {
+					t.Fatal(err)
+				}
+
+				if content.AcknowledgedMessageSequenceNumber != seq || content.AcknowledgedMessageType != OutputStreamData ||
+					content.AcknowledgedMessageId != m.messageID.String() {
+					t.Errorf("acknowledgement for message %d does not match the message: %+v", seq, content)
+				}
+			}
+
+			select {
+			case m := <-agent.msgs:
+				t.Errorf("unexpected extra message: %s", m)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			// each acknowledgement is a frame of its own
+			if n := atomic.LoadInt32(&agent.frames); int(n) != len(tc.seqs) {
+				t.Errorf("%d frames written to the websocket, want %d", n, len(tc.seqs))
+			}
+		})
+	}
+}
+
+// controlMessages returns n acknowledgements, a pause and start of publication, and a handshake complete message,
+// none of which have a payload for the reader.
+func controlMessages(t *testing.T, n int) []*AgentMessage {
+	t.Helper()
+
+	msgs := make([]*AgentMessage, 0, n+3)
+	for i := 0; i < n; i++ {
+		payload, err := json.Marshal(map[string]interface{}{
+			"AcknowledgedMessageType":           InputStreamData,
+			"AcknowledgedMessageId":             NewAgentMessage().messageID.String(),
+			"AcknowledgedMessageSequenceNumber": i,
+			"IsSequentialMessage":               true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ack := NewAgentMessage()
+		ack.MessageType = Acknowledge
+		ack.SequenceNumber = int64(i)
+		ack.Flags = Ack
+		ack.Payload = payload
+		msgs = append(msgs, ack)
+	}
+
+	for _, mt := range []MessageType{PausePublication, StartPublication} {
+		m := NewAgentMessage()
+		m.MessageType = mt
+		msgs = append(msgs, m)
+	}
+
+	done := NewAgentMessage()
+	done.MessageType = OutputStreamData
+	done.PayloadType = HandshakeComplete
+	done.Payload = []byte(`{"HandshakeTimeToComplete":1000000,"CustomerMessage":""}`)
+	return append(msgs, done)
+}
+
+// newControlAgent returns the URL of a websocket server which sends the control messages, then waits for idle before
+// sending the output and closing the channel.
+func newControlAgent(t *testing.T, control []*AgentMessage, idle time.Duration, output string) string {
+	t.Helper()
+
+	payload, err := json.Marshal(&ChannelClosedPayload{MessageType: "channel_closed", SchemaVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := NewAgentMessage()
+	closed.MessageType = ChannelClosed
+	closed.SequenceNumber = 1
+	closed.Payload = payload
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up := websocket.Upgrader{}
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		if _, _, err = ws.ReadMessage(); err != nil {
+			t.Errorf("error reading open data channel message: %v", err)
+			return
+		}
+
+		send := func(msgs ...*AgentMessage) bool {
+			for _, m := range msgs {
+				data, err := m.MarshalBinary()
+				if err == nil {
+					err = ws.WriteMessage(websocket.BinaryMessage, data)
+				}
+
+				if err != nil {
+					t.Errorf("error sending message to data channel: %v", err)
+					return false
+				}
+			}
+			return true
+		}
+
+		if !send(control...) {
+			return
+		}
+
+		time.Sleep(idle)
+		if !send(outputMessage(0, []byte(output)), closed) {
+			return
+		}
+
+		// read the acknowledgements until the client closes the connection
+		for {
+			if _, _, err = ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+// TestSsmDataChannel_ControlMessages checks that messages without a payload for the reader don't cause Read to return
+// empty reads, or to keep returning while the agent is idle.
+func TestSsmDataChannel_ControlMessages(t *testing.T) {
+	const (
+		acks   = 100
+		idle   = 100 * time.Millisecond
+		output = "session output"
+	)
+
+	control := controlMessages(t, acks)
+
+	t.Run("Read", func(t *testing.T) {
+		c := new(SsmDataChannel)
+		c.KeepAliveInterval = -1
+		if err := c.StartSessionFromDataChannelURL(newControlAgent(t, control, idle, output), "token"); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		var reads int
+		buf := make([]byte, 4096)
+		out := new(bytes.Buffer)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				t.Fatalf("read %d failed: %v", reads+1, err)
+			}
+			reads++
+
+			if n < agentMsgHeaderLen {
+				t.Fatalf("read %d returned %d bytes, want a complete message", reads, n)
+			}
+
+			payload, err := c.HandleMsg(buf[:n])
+			out.Write(payload)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 1 read per message, including the output and channel closed messages sent after the idle time
+		if want := len(control) + 2; reads != want {
+			t.Errorf("got %d reads, want %d", reads, want)
+		}
+
+		if out.String() != output {
+			t.Errorf("got output %q, want %q", out, output)
+		}
+	})
+
+	t.Run("WriteTo", func(t *testing.T) {
+		c := new(SsmDataChannel)
+		c.KeepAliveInterval = -1
+		if err := c.StartSessionFromDataChannelURL(newControlAgent(t, control, idle, output), "token"); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		out := new(bytes.Buffer)
+		if _, err := c.WriteTo(out); err != nil {
+			t.Fatal(err)
+		}
+
+		if out.String() != output {
+			t.Errorf("got output %q, want %q", out, output)
+		}
+
+		stats := c.Stats()
+		if stats.EmptyPayloads != int64(len(control)) {
+			t.Errorf("got %d empty payloads, want %d", stats.EmptyPayloads, len(control))
+		}
+
+		if stats.AcksReceived != acks {
+			t.Errorf("got %d acknowledgements, want %d", stats.AcksReceived, acks)
+		}
+	})
+}
+
+// outputMessage returns an output_stream_data message from the agent.
+func outputMessage(seq int64, payload []byte) *AgentMessage {
+	m := NewAgentMessage()
+	m.MessageType = OutputStreamData
+	m.PayloadType = Output
+	m.SequenceNumber = seq
+	m.Payload = payload
+	return m
+}
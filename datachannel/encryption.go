@@ -0,0 +1,181 @@
+package datachannel
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+const (
+	// kmsDataKeySize is the size of the data key requested from KMS, the first half is used to decrypt data sent
+	// by the agent, and the second half to encrypt data sent to the agent.
+	kmsDataKeySize = 64
+	// nonceSize is the size of the random nonce prepended to each encrypted payload.
+	nonceSize = 12
+)
+
+// KMSEncryptionResponse is the ActionResult of the KMSEncryption action sent in the handshake response.  The
+// KMSCipherTextHash is the SHA-256 hash of the KMSCipherTextKey, the same as sent by the session manager plugin.
+type KMSEncryptionResponse struct {
+	KMSCipherTextKey  []byte `json:"KMSCipherTextKey"`
+	KMSCipherTextHash []byte `json:"KMSCipherTextHash"`
+}
+
+// EncryptionChallenge is the payload of the EncChallengeRequest message sent by the agent to verify the client has
+// the session data key, and of the EncChallengeResponse reply.
+type EncryptionChallenge struct {
+	Challenge []byte `json:"Challenge"`
+}
+
+// encrypter encrypts and decrypts the session data using AES-GCM, with a data key generated by KMS.
+type encrypter struct {
+	encAEAD       cipher.AEAD
+	decAEAD       cipher.AEAD
+	cipherTextKey []byte
+}
+
+// newEncrypter generates a data key using the KMS key, with the session and target as the encryption context.  The
+// agent decrypts the returned cipherTextKey (sent in the handshake response) to get the same data key.
+func newEncrypter(ctx context.Context, cfg aws.Config, keyID, sessionID, target string) (*encrypter, error) {
+	out, err := kms.NewFromConfig(cfg).GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:         aws.String(keyID),
+		NumberOfBytes: aws.Int32(kmsDataKeySize),
+		EncryptionContext: map[string]string{
+			"aws:ssm:SessionId": sessionID,
+			"aws:ssm:TargetId":  target,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating KMS data key: %w", err)
+	}
+
+	half := len(out.Plaintext) / 2
+	e := &encrypter{cipherTextKey: out.CiphertextBlob}
+
+	if e.decAEAD, err = newAEAD(out.Plaintext[:half]); err != nil {
+		return nil, err
+	}
+
+	if e.encAEAD, err = newAEAD(out.Plaintext[half:]); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt returns the nonce followed by the encrypted data.
+func (e *encrypter) encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize, nonceSize+len(data)+e.encAEAD.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return e.encAEAD.Seal(nonce, nonce, data, nil), nil
+}
+
+// decrypt reverses encrypt, using the nonce at the start of the data.
+func (e *encrypter) decrypt(data []byte) ([]byte, error) {
+	if len(data) < nonceSize {
+		return nil, errors.New("encrypted payload too short")
+	}
+	return e.decAEAD.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+}
+
+// getEncrypter returns the encrypter for the session, or nil if the session data is not encrypted.
+func (c *SsmDataChannel) getEncrypter() *encrypter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enc
+}
+
+// processKMSEncryption handles the KMSEncryption action requested in the handshake, by generating the data key used
+// to encrypt the session data.  Once the action is processed, the payloads of data messages are encrypted.
+func (c *SsmDataChannel) processKMSEncryption(a RequestedClientAction) (ProcessedClientAction, error) {
+	action := ProcessedClientAction{ActionType: KMSEncryption, ActionStatus: Failed}
+
+	req := new(KMSEncryptionRequest)
+	err := convertActionParameters(a.ActionParameters, req)
+	if err == nil && c.awsCfg == nil {
+		err = errors.New("session encryption requires a session started with Open() or ResumeFromBookmark()")
+	}
+
+	var e *encrypter
+	if err == nil {
+		e, err = newEncrypter(context.Background(), *c.awsCfg, req.KMSKeyID, c.sessionID, c.target)
+	}
+
+	if err == nil {
+		hash := sha256.Sum256(e.cipherTextKey)
+		action.ActionResult, err = json.Marshal(&KMSEncryptionResponse{
+			KMSCipherTextKey:  e.cipherTextKey,
+			KMSCipherTextHash: hash[:],
+		})
+	}
+
+	if err != nil {
+		action.Error = fmt.Sprintf("failed to process action %s: %v", KMSEncryption, err)
+		return action, err
+	}
+
+	c.mu.Lock()
+	c.enc = e
+	c.info.KMSEncryption = true
+	c.mu.Unlock()
+
+	action.ActionStatus = Success
+	return action, nil
+}
+
+// processEncryptionChallenge answers the agent's encryption challenge, by decrypting the challenge and sending it
+// back encrypted, proving the client has the data key.
+func (c *SsmDataChannel) processEncryptionChallenge(msg *AgentMessage) error {
+	e := c.getEncrypter()
+	if e == nil {
+		return errors.New("encryption challenge received for an unencrypted session")
+	}
+
+	req := new(EncryptionChallenge)
+	if err := json.Unmarshal(msg.Payload, req); err != nil {
+		return err
+	}
+
+	challenge, err := e.decrypt(req.Challenge)
+	if err != nil {
+		return err
+	}
+
+	if challenge, err = e.encrypt(challenge); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&EncryptionChallenge{Challenge: challenge})
+	if err != nil {
+		return err
+	}
+
+	out := NewAgentMessage()
+	out.MessageType = InputStreamData
+	out.Flags = Data
+	out.PayloadType = EncChallengeResponse
+	out.Payload = payload
+	out.SequenceNumber = atomic.AddInt64(&c.seqNum, 1)
+
+	_, err = c.WriteMsg(out)
+	return err
+}
@@ -0,0 +1,222 @@
+package datachannel
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// kmsHTTPClient is an aws.HTTPClient which returns a KMS GenerateDataKey API response with the ciphertext.
+type kmsHTTPClient []byte
+
+func (c kmsHTTPClient) Do(*http.Request) (*http.Response, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"CiphertextBlob": []byte(c),
+		"KeyId":          "arn:aws:kms:us-east-1:123456789012:key/test",
+		"Plaintext":      bytes.Repeat([]byte{0x5a}, kmsDataKeySize),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+	}, nil
+}
+
+// newTestEncrypters returns the encrypter used by the client, and the encrypter used by the agent, for the same
+// data key.  The agent encrypts with the half of the key the client decrypts with, and vice versa.
+func newTestEncrypters(t *testing.T) (client, agent *encrypter) {
+	t.Helper()
+
+	key := make([]byte, kmsDataKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	half := len(key) / 2
+
+	var err error
+	client, agent = new(encrypter), new(encrypter)
+	for _, a := range []struct {
+		aead *cipher.AEAD
+		key  []byte
+	}{
+		{&client.decAEAD, key[:half]},
+		{&client.encAEAD, key[half:]},
+		{&agent.decAEAD, key[half:]},
+		{&agent.encAEAD, key[:half]},
+	} {
+		if *a.aead, err = newAEAD(a.key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client, agent
+}
+
+func TestEncrypter_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: []byte{}},
+		{name: "small", data: []byte("ls -l\n")},
+		{name: "max payload", data: bytes.Repeat([]byte{0xa5}, DefaultMaxPayloadSize)},
+	}
+
+	client, agent := newTestEncrypters(t)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, dir := range []struct {
+				name     string
+				from, to *encrypter
+			}{
+				{"client to agent", client, agent},
+				{"agent to client", agent, client},
+			} {
+				enc, err := dir.from.encrypt(tc.data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(tc.data) > 0 && bytes.Contains(enc, tc.data) {
+					t.Errorf("%s: encrypted payload contains the plaintext", dir.name)
+				}
+
+				dec, err := dir.to.decrypt(enc)
+				if err != nil {
+					t.Fatalf("%s: %v", dir.name, err)
+				}
+
+				if !bytes.Equal(dec, tc.data) {
+					t.Errorf("%s: decrypted %x, want %x", dir.name, dec, tc.data)
+				}
+
+				// each direction uses its own key, so the sender can't decrypt its own data
+				if _, err = dir.from.decrypt(enc); err == nil {
+					t.Errorf("%s: payload decrypted with the sending key", dir.name)
+				}
+
+				// a new nonce is used for every message
+				if enc2, _ := dir.from.encrypt(tc.data); bytes.Equal(enc, enc2) {
+					t.Errorf("%s: encrypting the same data twice gave the same result", dir.name)
+				}
+
+				enc[len(enc)-1] ^= 0xff
+				if _, err = dir.to.decrypt(enc); err == nil {
+					t.Errorf("%s: modified payload was decrypted", dir.name)
+				}
+			}
+		})
+	}
+
+	if _, err := client.decrypt(make([]byte, nonceSize-1)); err == nil {
+		t.Error("payload shorter than the nonce was decrypted")
+	}
+}
+
+func TestSsmDataChannel_EncryptedPayloads(t *testing.T) {
+	client, agent := newTestEncrypters(t)
+
+	c, remote := newTestChannel(t, &SsmDataChannel{MaxPayloadSize: 16})
+	c.enc = client
+
+	// data sent to the agent is chunked before encryption, so each message decrypts on its own
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(bytes.Buffer)
+	for got.Len() < len(data) {
+		m := remote.next(time.Second)
+		dec, err := agent.decrypt(m.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(dec) > 16 {
+			t.Errorf("decrypted payload is %d bytes, larger than the MaxPayloadSize", len(dec))
+		}
+		got.Write(dec)
+	}
+
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("agent received %q, want %q", got, data)
+	}
+
+	// output from the agent is decrypted before it is returned
+	enc, err := agent.encrypt([]byte("total 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := outputMessage(0, enc).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := c.HandleMsg(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(payload) != "total 0\n" {
+		t.Errorf("got output %q, want %q", payload, "total 0\n")
+	}
+}
+
+func TestSsmDataChannel_ProcessKMSEncryption(t *testing.T) {
+	cipherText := []byte("encrypted data key")
+
+	c := &SsmDataChannel{sessionID: "session-id", target: "i-0123456789abcdef0"}
+	c.awsCfg = &aws.Config{
+		Region:     "us-east-1",
+		HTTPClient: kmsHTTPClient(cipherText),
+		Retryer:    func() aws.Retryer { return aws.NopRetryer{} },
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+		}),
+	}
+
+	action, err := c.processKMSEncryption(RequestedClientAction{
+		ActionType:       KMSEncryption,
+		ActionParameters: map[string]string{"KMSKeyId": "alias/session"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if action.ActionStatus != Success {
+		t.Errorf("got action status %v, want %v", action.ActionStatus, Success)
+	}
+
+	res := new(KMSEncryptionResponse)
+	if err = json.Unmarshal(action.ActionResult, res); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(res.KMSCipherTextKey, cipherText) {
+		t.Errorf("got ciphertext key %q, want %q", res.KMSCipherTextKey, cipherText)
+	}
+
+	// the agent checks the hash of the ciphertext key it receives
+	if hash := sha256.Sum256(cipherText); !bytes.Equal(res.KMSCipherTextHash, hash[:]) {
+		t.Errorf("got ciphertext hash %x, want %x", res.KMSCipherTextHash, hash)
+	}
+
+	if c.getEncrypter() == nil || !c.info.KMSEncryption {
+		t.Error("session data is not encrypted after processing the action")
+	}
+}
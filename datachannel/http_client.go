@@ -0,0 +1,30 @@
+package datachannel
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// DialContextFunc is the signature of a function used to make network connections, matching net.Dialer.DialContext.
+type DialContextFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// WithDialContext returns a copy of the provided aws.Config whose HTTP client uses the dial function to make the
+// network connections for AWS API calls (like ssm:StartSession and ec2:DescribeInstances).  This can be used to
+// route API calls through a specific egress path, like a VPC interface endpoint.  If the config already has an
+// HTTP client built by the AWS SDK, its other settings are kept.  A fully custom HTTP client can be used by setting
+// the HTTPClient field of the aws.Config directly.
+func WithDialContext(cfg aws.Config, dial DialContextFunc) aws.Config {
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok || client == nil {
+		client = awshttp.NewBuildableClient()
+	}
+
+	cfg.HTTPClient = client.WithTransportOptions(func(tr *http.Transport) {
+		tr.DialContext = dial
+	})
+	return cfg
+}
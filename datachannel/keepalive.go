@@ -0,0 +1,62 @@
+package datachannel
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultKeepAliveInterval is the interval between the websocket ping frames sent to keep the data channel open,
+// unless changed by setting SsmDataChannel.KeepAliveInterval.  Without pings, idle port forwarding sessions have been
+// seen to be dropped after about 30 seconds.  The interval needs to be well inside the shortest idle timeout seen
+// between here and the service (including proxies and load balancers), so that a single delayed ping does not let
+// the connection expire.  A ping frame is only a few bytes, so the cost of the shorter interval is negligible, and
+// long-lived idle sessions can set a longer interval.
+const DefaultKeepAliveInterval = 5 * time.Second
+
+// startKeepAlive sends websocket ping frames every KeepAliveInterval until the data channel is closed.  If
+// KeepAliveTimeout is set, the read deadline of the websocket connection is set so that Read fails if no message
+// (or pong reply to a ping) is received within the timeout.
+func (c *SsmDataChannel) startKeepAlive() {
+	ws := c.conn()
+
+	if c.KeepAliveTimeout > 0 {
+		_ = ws.SetReadDeadline(time.Now().Add(c.KeepAliveTimeout))
+		ws.SetPongHandler(func(string) error {
+			return c.extendReadDeadline()
+		})
+	}
+
+	interval := c.KeepAliveInterval
+	if interval == 0 {
+		interval = DefaultKeepAliveInterval
+	}
+
+	if interval < 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for range t.C {
+			if !c.isOpen() {
+				return
+			}
+
+			// WriteControl is safe to call concurrently with the other websocket write methods
+			if err := ws.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// extendReadDeadline moves the read deadline of the websocket connection to KeepAliveTimeout from now, if set.
+func (c *SsmDataChannel) extendReadDeadline() error {
+	if c.KeepAliveTimeout > 0 {
+		return c.conn().SetReadDeadline(time.Now().Add(c.KeepAliveTimeout))
+	}
+	return nil
+}
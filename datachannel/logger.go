@@ -0,0 +1,76 @@
+package datachannel
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Logger is the interface used for the log messages written by this library, which allows the messages to be sent
+// to the logging package used by the rest of an application.  The methods use fmt.Printf style formatting.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// DiscardLogger is a Logger which discards all messages, to silence the library's log output.
+var DiscardLogger Logger = discardLogger{}
+
+var currentLogger atomic.Value
+
+// loggerValue wraps the Logger, since an atomic.Value must always store the same concrete type.
+type loggerValue struct {
+	Logger
+}
+
+// SetLogger sets the Logger used by this package, and the ssmclient package.  A nil Logger restores the default,
+// which writes all messages using the standard library log package.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	currentLogger.Store(loggerValue{l})
+}
+
+// CurrentLogger returns the Logger set with SetLogger, or the default Logger.
+func CurrentLogger() Logger {
+	if v, ok := currentLogger.Load().(loggerValue); ok {
+		return v.Logger
+	}
+	return stdLogger{}
+}
+
+// logger is shorthand for CurrentLogger.
+func logger() Logger {
+	return CurrentLogger()
+}
+
+// stdLogger writes messages of all levels using the standard library log package.
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (stdLogger) Infof(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (stdLogger) Warnf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (stdLogger) Errorf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debugf(string, ...interface{}) {}
+
+func (discardLogger) Infof(string, ...interface{}) {}
+
+func (discardLogger) Warnf(string, ...interface{}) {}
+
+func (discardLogger) Errorf(string, ...interface{}) {}
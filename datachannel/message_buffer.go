@@ -25,6 +25,8 @@ type messageBuffer struct {
 }
 
 func (m *messageBuffer) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	return m.buf.Len()
 }
 
@@ -32,7 +34,7 @@ func (m *messageBuffer) Add(msg *AgentMessage) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.Len() == m.size {
+	if m.buf.Len() == m.size {
 		return ErrBufferFull
 	}
 
@@ -84,6 +86,18 @@ func (m *messageBuffer) Next() *AgentMessage {
 	return nil
 }
 
+// messages returns the buffered messages, in the order they were added.
+func (m *messageBuffer) messages() []*AgentMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	msgs := make([]*AgentMessage, 0, m.buf.Len())
+	for el := m.buf.Front(); el != nil; el = el.Next() {
+		msgs = append(msgs, el.Value.(*AgentMessage))
+	}
+	return msgs
+}
+
 func NewMessageBuffer(size int) *messageBuffer {
 	mb := new(messageBuffer)
 	mb.size = size
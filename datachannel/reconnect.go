@@ -0,0 +1,114 @@
+package datachannel
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultReconnectBackoff is the delay before the 1st reconnect attempt, unless changed by setting
+// SsmDataChannel.ReconnectBackoff.  The delay is doubled for each subsequent attempt.
+const DefaultReconnectBackoff = 1 * time.Second
+
+// canReconnect returns true if the read error is from a websocket connection which was dropped (not closed by
+// either end of the session), and reconnect attempts remain.  The session ID is only known if the session was
+// started with Open() or ResumeFromBookmark().
+func (c *SsmDataChannel) canReconnect(err error) bool {
+	if c.MaxReconnects < 1 || c.reconnects >= c.MaxReconnects || c.awsCfg == nil {
+		return false
+	}
+
+	if atomic.LoadInt32(&c.closed) == 1 || atomic.LoadInt32(&c.peerClosed) == 1 {
+		return false
+	}
+	return !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// reconnect attempts to resume the session, with an increasing delay between attempts, until it succeeds or
+// MaxReconnects attempts have been made over the life of the data channel.
+func (c *SsmDataChannel) reconnect() error {
+	backoff := c.ReconnectBackoff
+	if backoff <= 0 {
+		backoff = DefaultReconnectBackoff
+	}
+
+	err := errors.New("no reconnect attempts remaining")
+	for c.reconnects < c.MaxReconnects {
+		c.reconnects++
+		time.Sleep(backoff)
+		backoff *= 2
+
+		if atomic.LoadInt32(&c.closed) == 1 {
+			return ErrNotOpen
+		}
+
+		if err = c.resume(); err == nil {
+			atomic.AddInt64(&c.stats.Reconnects, 1)
+			logger().Infof("reconnected session %s", c.sessionID)
+			return nil
+		}
+		logger().Warnf("reconnect attempt %d of %d failed: %v", c.reconnects, c.MaxReconnects, err)
+	}
+	return err
+}
+
+// resume calls the ResumeSession API, and replaces the websocket connection with a connection to the new stream
+// URL.  Messages which have not been acknowledged by the agent are sent again over the new connection (if the
+// outbound buffer is in use), before any new messages.
+func (c *SsmDataChannel) resume() error {
+	in := &ssm.ResumeSessionInput{SessionId: aws.String(c.sessionID)}
+	out, err := ssm.NewFromConfig(*c.awsCfg).ResumeSession(context.Background(), in)
+	if err != nil {
+		return err
+	}
+
+	ws, err := dialWebsocket(context.Background(), c.Dialer, aws.ToString(out.StreamUrl))
+	if err != nil {
+		return err
+	}
+
+	// the data channel must be opened before anything else is sent over the new connection
+	if err = ws.WriteJSON(openDataChannelInput(aws.ToString(out.TokenValue))); err != nil {
+		_ = ws.Close()
+		return err
+	}
+
+	// holding c.mu keeps other writes off the new connection until the unacknowledged messages are resent
+	c.mu.Lock()
+	old := c.conn()
+	c.ws.Store(ws)
+	c.streamURL = aws.ToString(out.StreamUrl)
+	err = c.resendUnacknowledged()
+	c.mu.Unlock()
+
+	_ = old.Close()
+	c.startKeepAlive()
+	return err
+}
+
+// resendUnacknowledged sends the messages in the outbound buffer, which the agent has not acknowledged, in the order
+// they were first sent.  The caller must hold c.mu.
+func (c *SsmDataChannel) resendUnacknowledged() error {
+	buf, ok := c.outMsgBuf.(*messageBuffer)
+	if !ok {
+		return nil
+	}
+
+	for _, m := range buf.messages() {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		c.debugEvent(EventRetransmit, m)
+		if err = c.writeMessage(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package datachannel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/websocket"
+)
+
+// resumeHTTPClient is an aws.HTTPClient which returns a ResumeSession API response with the stream URL.
+type resumeHTTPClient string
+
+func (c resumeHTTPClient) Do(*http.Request) (*http.Response, error) {
+	body, err := json.Marshal(map[string]string{
+		"SessionId":  "session-id",
+		"StreamUrl":  string(c),
+		"TokenValue": "resume-token",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/x-amz-json-1.1"}},
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+	}, nil
+}
+
+// receivedMessage is a message received by the websocket server in TestSsmDataChannel_Reconnect, and the number of
+// the connection it was received on.
+type receivedMessage struct {
+	conn int
+	msg  *AgentMessage
+}
+
+func TestSsmDataChannel_Reconnect(t *testing.T) {
+	const dropAfter = 2 // messages received before the 1st connection is dropped
+
+	received := make(chan receivedMessage, 100)
+	tokens := make(chan string, 2)
+
+	var mu sync.Mutex
+	var conns int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		conns++
+		conn := conns
+		mu.Unlock()
+
+		up := websocket.Upgrader{}
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		open := new(struct{ TokenValue string })
+		if err = ws.ReadJSON(open); err != nil {
+			t.Errorf("error reading open data channel message: %v", err)
+			return
+		}
+		tokens <- open.TokenValue
+
+		for n := 1; ; n++ {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			m := new(AgentMessage)
+			if err = m.UnmarshalBinary(data); err != nil {
+				t.Errorf("invalid message from data channel: %v", err)
+				return
+			}
+			received <- receivedMessage{conn: conn, msg: m}
+
+			// drop the connection without a close frame, the same as a network failure
+			if conn == 1 && n == dropAfter {
+				_ = ws.UnderlyingConn().Close()
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	c := &SsmDataChannel{
+		MaxReconnects:     1,
+		ReconnectBackoff:  time.Millisecond,
+		KeepAliveInterval: 10 * time.Millisecond,
+		KeepAliveTimeout:  time.Second,
+	}
+	c.outMsgBuf = NewMessageBuffer(50)
+	c.sessionID = "session-id"
+	c.awsCfg = &aws.Config{
+		Region:     "us-east-1",
+		HTTPClient: resumeHTTPClient(url),
+		Retryer:    func() aws.Retryer { return aws.NopRetryer{} },
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+		}),
+	}
+
+	if err := c.StartSessionFromDataChannelURL(url, "token"); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// the read is in progress when the connection drops, and reconnects from the reading goroutine
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := c.Read(buf); err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	// other goroutines use the connection while it's being replaced
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = c.isOpen()
+				_ = c.LocalAddr()
+				_ = c.extendReadDeadline()
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// the agent doesn't acknowledge anything, so both messages are resent after reconnecting
+	sent := make(map[int64]string)
+	for _, data := range []string{"first", "second"} {
+		if _, err := c.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	next := func() receivedMessage {
+		t.Helper()
+
+		select {
+		case m := <-received:
+			return m
+		case err := <-readErr:
+			t.Fatalf("read failed: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for message from data channel")
+		}
+		return receivedMessage{}
+	}
+
+	for i := 0; i < dropAfter; i++ {
+		m := next()
+		sent[m.msg.SequenceNumber] = string(m.msg.Payload)
+	}
+
+	for i, want := range []string{"token", "resume-token"} {
+		select {
+		case got := <-tokens:
+			if got != want {
+				t.Errorf("connection %d opened with token %q, want %q", i+1, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection %d was not opened", i+1)
+		}
+	}
+
+	// the unacknowledged messages are sent again, in order, before anything new
+	var lastSeq int64 = -1
+	for i := 0; i < dropAfter; i++ {
+		m := next()
+		if m.conn != 2 {
+			t.Fatalf("message received on connection %d after reconnecting", m.conn)
+		}
+
+		data, ok := sent[m.msg.SequenceNumber]
+		if !ok || data != string(m.msg.Payload) {
+			t.Errorf("resent message %d is %q, which was not sent before reconnecting", m.msg.SequenceNumber,
+				m.msg.Payload)
+		}
+
+		if m.msg.SequenceNumber <= lastSeq {
+			t.Errorf("message %d resent after message %d", m.msg.SequenceNumber, lastSeq)
+		}
+		lastSeq = m.msg.SequenceNumber
+	}
+
+	if _, err := c.Write([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := next(); m.conn != 2 || string(m.msg.Payload) != "third" {
+		t.Errorf("got %q on connection %d, want %q on connection 2", m.msg.Payload, m.conn, "third")
+	}
+
+	if n := c.Stats().Reconnects; n != 1 {
+		t.Errorf("got %d reconnects, want 1", n)
+	}
+}
@@ -0,0 +1,65 @@
+package datachannel
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidStreamURL is the error returned by ParseStreamURL for a URL which is not a session data channel URL.
+var ErrInvalidStreamURL = errors.New("invalid session stream URL")
+
+// dataChannelPath is the path prefix of the StreamUrl returned by the StartSession and ResumeSession APIs, which is
+// followed by the session ID.
+const dataChannelPath = "/v1/data-channel/"
+
+// ParseStreamURL checks that streamURL is a session data channel URL, as returned in the StreamUrl field of the
+// StartSession and ResumeSession API output (wss://ssmmessages.<region>.amazonaws.com/v1/data-channel/<session id>),
+// and returns the session ID from the URL.  The ws scheme is also accepted, for use with a local test service.
+func ParseStreamURL(streamURL string) (string, error) {
+	u, err := url.Parse(streamURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidStreamURL, err)
+	}
+
+	if u.Scheme != "wss" && u.Scheme != "ws" {
+		return "", fmt.Errorf("%w: unsupported scheme %q", ErrInvalidStreamURL, u.Scheme)
+	}
+
+	if len(u.Host) < 1 {
+		return "", fmt.Errorf("%w: missing host", ErrInvalidStreamURL)
+	}
+
+	id := strings.TrimPrefix(u.Path, dataChannelPath)
+	if id == u.Path || len(id) < 1 || strings.Contains(id, "/") {
+		return "", fmt.Errorf("%w: missing session ID in path %q", ErrInvalidStreamURL, u.Path)
+	}
+	return id, nil
+}
+
+// OpenWithStream opens the data channel for a session which was started elsewhere (for example, by a backend
+// service which makes the StartSession API call on behalf of the client), using the StreamUrl and TokenValue from
+// the StartSession API output.  The stream URL is checked with ParseStreamURL, and the session ID is taken from it.
+// OnSessionStart and PreHandshake are called the same as for Open.  Since no AWS credentials are available to the
+// data channel, sessions using KMS encryption, and reconnecting with ResumeSession (MaxReconnects), are not
+// supported, and a BookmarkPath is not written.  The token is only valid for a short time after the session is
+// started, so this should be called promptly.
+func (c *SsmDataChannel) OpenWithStream(streamURL, token string) error {
+	id, err := ParseStreamURL(streamURL)
+	if err != nil {
+		return err
+	}
+
+	if len(token) < 1 {
+		return errors.New("stream token is required")
+	}
+
+	c.init()
+	c.sessionID = id
+	c.streamURL = streamURL
+	if c.OnSessionStart != nil {
+		c.OnSessionStart(c.sessionID)
+	}
+	return c.connect(streamURL, token, true)
+}
@@ -93,6 +93,11 @@ type SessionTypeRequest struct {
 	Properties  interface{}
 }
 
+// KMSEncryptionRequest is the ActionParameters of the KMSEncryption action requested as part of the handshake.
+type KMSEncryptionRequest struct {
+	KMSKeyID string `json:"KMSKeyId"`
+}
+
 // HandshakeResponsePayload is the local client response to the offered handshake request.  The ProcessedClientActions
 // field should have an entry for each RequestedClientActions in the handshake request.
 type HandshakeResponsePayload struct {
@@ -125,3 +130,74 @@ type ChannelClosedPayload struct {
 	CreatedDate   string
 	Output        string
 }
+
+// SessionInfo contains the session properties negotiated with the agent during the session handshake.
+type SessionInfo struct {
+	AgentVersion      string        // the version of the SSM agent on the target
+	ClientVersion     string        // the version reported to the agent in the handshake response
+	SchemaVersion     uint32        // the message schema version used by the agent
+	SessionType       string        // the type of session (ex. Port, InteractiveCommands) requested by the agent
+	Multiplexing      bool          // true if the agent will multiplex port forwarding connections
+	KMSEncryption     bool          // true if session data is encrypted using the KMS key
+	KMSKeyID          string        // the KMS key requested by the agent to encrypt session data
+	HandshakeComplete bool          // true if the agent completed the handshake
+	HandshakeTime     time.Duration // the time the agent reported it took to complete the handshake
+	CustomerMessage   string        // any message for the user included in the handshake completion
+}
+
+// AgentVersionAtLeast returns true if the agent version is the same as, or newer than, the version v.
+func (i SessionInfo) AgentVersionAtLeast(v string) bool {
+	return versionAtLeast(i.AgentVersion, v)
+}
+
+// UnknownMessagePolicy determines how the data channel handles messages with a message type or payload type it
+// doesn't recognize, which may be sent by agent versions newer than this library.
+type UnknownMessagePolicy int
+
+const (
+	// UnknownMessageLog logs a warning, then acknowledges and discards the message.  This is the default.
+	UnknownMessageLog UnknownMessagePolicy = iota
+	// UnknownMessageIgnore acknowledges and discards the message, without logging.
+	UnknownMessageIgnore
+	// UnknownMessageError returns an error from HandleMsg, which ends most sessions.
+	UnknownMessageError
+)
+
+// DebugEventType identifies the protocol activity reported by a DebugEvent.
+type DebugEventType string
+
+const (
+	EventMessageSent      DebugEventType = "message_sent"
+	EventMessageAcked     DebugEventType = "message_acked"
+	EventRetransmit       DebugEventType = "retransmit"
+	EventPausePublication DebugEventType = "pause_publication"
+	EventStartPublication DebugEventType = "start_publication"
+	EventHandshakeRequest DebugEventType = "handshake_request"
+	EventHandshakeDone    DebugEventType = "handshake_complete"
+	EventTaskMessage      DebugEventType = "task_message"
+)
+
+// DebugEvent describes a single step of the data channel protocol, sent to SsmDataChannel.DebugEvents.  The
+// message fields describe the message sent or received which caused the event.
+type DebugEvent struct {
+	Type           DebugEventType
+	MessageType    MessageType
+	PayloadType    PayloadType
+	SequenceNumber int64
+	Time           time.Time
+}
+
+// ChannelStats contains counters describing the message traffic on a data channel.
+type ChannelStats struct {
+	AcksReceived int64 // Acknowledge messages received from the agent
+	UnknownAcks  int64 // Acknowledge messages received for a message not in the outbound buffer
+
+	// EmptyPayloads counts messages handled without returning any data to the caller (acknowledgements and
+	// other control messages, or output queued while waiting for an earlier message).  A value growing much
+	// faster than the amount of session output indicates an agent sending an unusual volume of control traffic.
+	EmptyPayloads int64
+
+	BytesSent     int64 // payload bytes sent by Write and WriteMessage
+	BytesReceived int64 // payload bytes returned by HandleMsg (session output)
+	Reconnects    int64 // successful reconnects after the websocket connection was dropped
+}
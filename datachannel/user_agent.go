@@ -0,0 +1,28 @@
+package datachannel
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+)
+
+const (
+	// UserAgentName identifies this library in the User-Agent header of AWS API calls.
+	UserAgentName = "ssm-session-client"
+	// Version is the version of this library reported in the User-Agent header of AWS API calls.
+	Version = "0.0.1"
+)
+
+// WithUserAgent returns a copy of the provided aws.Config which adds the name/version pair to the User-Agent header
+// of the AWS API calls made by clients created from the returned config.  All AWS API calls made by this library
+// are already identified using UserAgentName and Version, this can be used to further identify the calling program.
+func WithUserAgent(cfg aws.Config, name, version string) aws.Config {
+	// use a full slice expression so the append doesn't modify the APIOptions of the original config
+	n := len(cfg.APIOptions)
+	cfg.APIOptions = append(cfg.APIOptions[:n:n], awsmiddleware.AddUserAgentKeyValue(name, version))
+	return cfg
+}
+
+// withDefaultUserAgent returns a copy of the aws.Config which identifies this library in the User-Agent header.
+func withDefaultUserAgent(cfg aws.Config) aws.Config {
+	return WithUserAgent(cfg, UserAgentName, Version)
+}
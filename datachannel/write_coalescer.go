@@ -0,0 +1,71 @@
+package datachannel
+
+import "time"
+
+const (
+	// DefaultWriteCoalesceDelay is a reasonable value for SsmDataChannel.WriteCoalesceDelay which will combine
+	// bursts of small writes without adding noticeable latency.
+	DefaultWriteCoalesceDelay = 5 * time.Millisecond
+
+	// maxCoalesceSize is the amount of buffered data which will trigger an immediate send, regardless of the
+	// coalescing delay.
+	maxCoalesceSize = 1536
+)
+
+// Flush immediately sends any data buffered as part of write coalescing.  It is safe to call if write
+// coalescing is not enabled.
+func (c *SsmDataChannel) Flush() error {
+	c.coalesceMu.Lock()
+	defer c.coalesceMu.Unlock()
+
+	return c.flushLocked()
+}
+
+// coalesceWrite adds the payload to the pending data buffer, and arranges for the buffer to be sent once the
+// WriteCoalesceDelay expires. If the buffer grows beyond maxCoalesceSize, the data is sent immediately.
+// Errors sending data from the timer are reported on the next call to Write.
+func (c *SsmDataChannel) coalesceWrite(payload []byte) (int, error) {
+	c.coalesceMu.Lock()
+	defer c.coalesceMu.Unlock()
+
+	if err := c.coalesceErr; err != nil {
+		c.coalesceErr = nil
+		return 0, err
+	}
+
+	c.coalesceBuf = append(c.coalesceBuf, payload...)
+	if len(c.coalesceBuf) >= maxCoalesceSize {
+		return len(payload), c.flushLocked()
+	}
+
+	if c.coalesceTimer == nil {
+		c.coalesceTimer = time.AfterFunc(c.WriteCoalesceDelay, func() {
+			c.coalesceMu.Lock()
+			defer c.coalesceMu.Unlock()
+
+			if err := c.flushLocked(); err != nil {
+				c.coalesceErr = err
+			}
+		})
+	}
+
+	return len(payload), nil
+}
+
+// flushLocked sends the pending coalesced data, the caller must hold coalesceMu.
+func (c *SsmDataChannel) flushLocked() error {
+	if c.coalesceTimer != nil {
+		c.coalesceTimer.Stop()
+		c.coalesceTimer = nil
+	}
+
+	if len(c.coalesceBuf) < 1 {
+		return nil
+	}
+
+	data := c.coalesceBuf
+	c.coalesceBuf = nil
+
+	_, err := c.writePayload(data)
+	return err
+}
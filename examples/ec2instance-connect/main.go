@@ -2,9 +2,7 @@ package main
 
 import (
 	"context"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
 	"github.com/mmmorris1975/ssm-session-client/ssmclient"
 	"log"
 	"net"
@@ -63,13 +61,9 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ec2i := ec2instanceconnect.NewFromConfig(cfg)
-	pubkeyIn := ec2instanceconnect.SendSSHPublicKeyInput{
-		InstanceId:     aws.String(tgt),
-		InstanceOSUser: aws.String(userHost[0]),
-		SSHPublicKey:   aws.String(""), // FIXME - load your SSH public key here
-	}
-	if _, err = ec2i.SendSSHPublicKey(context.Background(), &pubkeyIn); err != nil {
+	// uses the 1st suitable key from the SSH agent, or from the default key files in ~/.ssh
+	pubKey, err := ssmclient.FindSSHPublicKey()
+	if err != nil {
 		log.Fatal(err)
 	}
 
@@ -78,6 +72,6 @@ func main() {
 		RemotePort: port,
 	}
 
-	// Alternatively, can be called as ssmclient.SSHPluginSession(cfg, tgt) to use the AWS-managed SSM session client code
-	log.Fatal(ssmclient.SSHSession(cfg, &in))
+	// sends the public key using the EC2 Instance Connect API, then starts the SSH session
+	log.Fatal(ssmclient.EC2InstanceConnectSSHSession(cfg, &in, userHost[0], pubKey))
 }
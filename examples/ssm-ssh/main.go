@@ -53,8 +53,9 @@ func main() {
 	}
 
 	in := ssmclient.PortForwardingInput{
-		Target:     tgt,
-		RemotePort: port,
+		Target:           tgt,
+		RemotePort:       port,
+		DisconnectOnExit: true,
 	}
 
 	// Alternatively, can be called as ssmclient.SSHPluginSession(cfg, tgt) to use the AWS-managed SSM session client code
@@ -0,0 +1,275 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// recordEnv enables recording the cassettes using live AWS services, instead of replaying them.
+	recordEnv = "SSM_INTEGRATION_RECORD"
+	// targetEnv is the target spec resolved (and connected to) while recording.
+	targetEnv = "SSM_INTEGRATION_TARGET"
+
+	// streamURLPlaceholder replaces the stream URL returned by StartSession in the recorded response, and is
+	// replaced with the URL of the test agent when replayed.
+	streamURLPlaceholder = "{{stream_url}}"
+	// tokenPlaceholder replaces the data channel token returned by StartSession in the recorded response.
+	tokenPlaceholder = "{{token}}"
+)
+
+// cassette is the recording of the AWS API calls made by a test, which are replayed in place of the AWS services.
+// Target is the target spec which was resolved to the InstanceID.  Output is the data received from the session
+// data channel, which is sent by the test agent when replayed.
+type cassette struct {
+	Region       string         `yaml:"region"`
+	Target       string         `yaml:"target"`
+	InstanceID   string         `yaml:"instance_id"`
+	Output       string         `yaml:"output,omitempty"`
+	Interactions []*interaction `yaml:"interactions"`
+}
+
+// interaction is a single AWS API request, and its response.
+type interaction struct {
+	Operation   string `yaml:"operation"`
+	Request     string `yaml:"request"`
+	Status      int    `yaml:"status"`
+	ContentType string `yaml:"content_type"`
+	Response    string `yaml:"response"`
+}
+
+// recording returns true if the cassettes are being recorded.
+func recording() bool {
+	return len(os.Getenv(recordEnv)) > 0
+}
+
+// loadCassette returns the named cassette from the testdata directory, or a new cassette for the target in the
+// targetEnv environment variable if recording (skipping the test if it's not set).  The cassette is saved when
+// the test ends, if recording.
+func loadCassette(t *testing.T, name string) *cassette {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".yaml")
+
+	if recording() {
+		target := os.Getenv(targetEnv)
+		if len(target) < 1 {
+			t.Skipf("%s must be set to record %s", targetEnv, path)
+		}
+
+		c := &cassette{Target: target}
+		t.Cleanup(func() {
+			if t.Failed() {
+				return
+			}
+
+			data, err := yaml.Marshal(c)
+			if err == nil {
+				err = os.WriteFile(path, data, 0600)
+			}
+
+			if err != nil {
+				t.Errorf("error saving cassette: %v", err)
+			}
+		})
+		return c
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(cassette)
+	if err = yaml.Unmarshal(data, c); err != nil {
+		t.Fatalf("invalid cassette %s: %v", path, err)
+	}
+	return c
+}
+
+// awsConfig returns the AWS config for the test.  When recording, the default config is loaded from the
+// environment, and the API calls are recorded in the cassette.  Otherwise the API calls are replayed from the
+// cassette, with stream URLs replaced by streamURL.
+func (c *cassette) awsConfig(t *testing.T, streamURL string) aws.Config {
+	t.Helper()
+
+	if recording() {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := cfg.HTTPClient
+		if next == nil {
+			next = awshttp.NewBuildableClient()
+		}
+
+		c.Region = cfg.Region
+		cfg.HTTPClient = &recorder{next: next, cassette: c}
+		return cfg
+	}
+
+	p := &player{t: t, cassette: c, streamURL: streamURL}
+	t.Cleanup(func() {
+		if n := len(c.Interactions) - p.next; n > 0 {
+			t.Errorf("%d recorded API calls were not made", n)
+		}
+	})
+
+	// a call which doesn't match the cassette fails the test, retrying it would only replay the next call
+	return aws.Config{
+		Region:     c.Region,
+		HTTPClient: p,
+		Retryer:    func() aws.Retryer { return aws.NopRetryer{} },
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "integration"}, nil
+		}),
+	}
+}
+
+// operation returns the service and API operation of the request, like ssm:StartSession.
+func operation(r *http.Request, body []byte) string {
+	service := strings.SplitN(r.URL.Host, ".", 2)[0]
+
+	// JSON protocol services (SSM) name the operation in a header, query protocol services (EC2) in the body
+	if target := r.Header.Get("X-Amz-Target"); len(target) > 0 {
+		return service + ":" + target[strings.LastIndex(target, ".")+1:]
+	}
+
+	if v, err := url.ParseQuery(string(body)); err == nil && len(v.Get("Action")) > 0 {
+		return service + ":" + v.Get("Action")
+	}
+	return service + ":" + r.Method + " " + r.URL.Path
+}
+
+// readBody returns the body of the request, leaving the body of the request readable.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = r.Body.Close()
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recorder is an aws.HTTPClient which adds each request made using the next client to the cassette.
+type recorder struct {
+	next     aws.HTTPClient
+	mu       sync.Mutex
+	cassette *cassette
+}
+
+func (r *recorder) Do(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.next.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(data))
+
+	i := &interaction{
+		Operation:   operation(req, body),
+		Request:     string(body),
+		Status:      res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Response:    string(data),
+	}
+
+	// the stream URL and token are only valid for this session, and the token is a credential
+	if i.Operation == "ssm:StartSession" && res.StatusCode == http.StatusOK {
+		out := make(map[string]interface{})
+		if err = json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+		out["StreamUrl"] = streamURLPlaceholder
+		out["TokenValue"] = tokenPlaceholder
+
+		scrubbed, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		i.Response = string(scrubbed)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, i)
+	r.mu.Unlock()
+
+	return res, nil
+}
+
+// player is an aws.HTTPClient which returns the responses recorded in the cassette, in order.  The test fails if
+// a request doesn't match the recorded request.
+type player struct {
+	t         *testing.T
+	mu        sync.Mutex
+	cassette  *cassette
+	streamURL string
+	next      int
+}
+
+func (p *player) Do(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	op := operation(req, body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.next >= len(p.cassette.Interactions) {
+		p.t.Errorf("unexpected API call %s, not recorded in the cassette", op)
+		return nil, fmt.Errorf("no recorded response for %s", op)
+	}
+
+	i := p.cassette.Interactions[p.next]
+	p.next++
+
+	if op != i.Operation || string(body) != i.Request {
+		p.t.Errorf("API call %s does not match the recorded call %s\ngot:  %s\nwant: %s", op, i.Operation, body,
+			i.Request)
+		return nil, fmt.Errorf("API call %s does not match the cassette", op)
+	}
+
+	return &http.Response{
+		StatusCode: i.Status,
+		Header:     http.Header{"Content-Type": []string{i.ContentType}},
+		Body:       io.NopCloser(strings.NewReader(strings.ReplaceAll(i.Response, streamURLPlaceholder, p.streamURL))),
+		Request:    req,
+	}, nil
+}
@@ -0,0 +1,143 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+	"github.com/mmmorris1975/ssm-session-client/ssmclient"
+)
+
+// testCommand is run on the target by the session tests, its output is checked for commandOutput.
+const (
+	testCommand   = "echo ssm-session-client"
+	commandOutput = "ssm-session-client"
+)
+
+// newTestAgent returns the URL of a websocket server which acts as the SSM agent for a replayed session.  Once the
+// data channel is opened, the agent sends the output, then closes the session.  When recording, the session is
+// with the real agent, and the URL is empty.
+func newTestAgent(t *testing.T, output string) string {
+	t.Helper()
+
+	if recording() {
+		return ""
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		up := websocket.Upgrader{}
+		ws, err := up.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("websocket upgrade failed: %v", err)
+			return
+		}
+		defer ws.Close()
+
+		// the 1st message opens the data channel, and is not an AgentMessage
+		if _, _, err = ws.ReadMessage(); err != nil {
+			t.Errorf("error reading open data channel message: %v", err)
+			return
+		}
+
+		out := datachannel.NewAgentMessage()
+		out.MessageType = datachannel.OutputStreamData
+		out.PayloadType = datachannel.Output
+		out.Payload = []byte(output)
+
+		payload, err := json.Marshal(&datachannel.ChannelClosedPayload{MessageType: "channel_closed", SchemaVersion: 1})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		closed := datachannel.NewAgentMessage()
+		closed.MessageType = datachannel.ChannelClosed
+		closed.SequenceNumber = 1
+		closed.Payload = payload
+
+		for _, m := range []*datachannel.AgentMessage{out, closed} {
+			data, err := m.MarshalBinary()
+			if err == nil {
+				err = ws.WriteMessage(websocket.BinaryMessage, data)
+			}
+
+			if err != nil {
+				t.Errorf("error sending message to data channel: %v", err)
+				return
+			}
+		}
+
+		// read the acknowledgements until the client closes the connection
+		for {
+			if _, _, err = ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestResolveTarget(t *testing.T) {
+	tests := []string{"resolve_tag"}
+
+	for _, name := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := loadCassette(t, name)
+
+			id, err := ssmclient.ResolveTarget(c.Target, c.awsConfig(t, ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if recording() {
+				c.InstanceID = id
+				return
+			}
+
+			if id != c.InstanceID {
+				t.Errorf("resolved %s to %s, want %s", c.Target, id, c.InstanceID)
+			}
+		})
+	}
+}
+
+// TestNonInteractiveCommandSession resolves the target, and runs a command on the instance, checking the resolved
+// instance ID is used to start the session.
+func TestNonInteractiveCommandSession(t *testing.T) {
+	c := loadCassette(t, "command_session")
+	cfg := c.awsConfig(t, newTestAgent(t, c.Output))
+
+	id, err := ssmclient.ResolveTarget(c.Target, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !recording() && id != c.InstanceID {
+		t.Fatalf("resolved %s to %s, want %s", c.Target, id, c.InstanceID)
+	}
+
+	out := new(bytes.Buffer)
+	err = ssmclient.NonInteractiveCommandSessionWithInput(cfg, &ssmclient.ShellSessionInput{Target: id}, testCommand, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), commandOutput) {
+		t.Errorf("got command output %q, want %q", out, commandOutput)
+	}
+
+	if recording() {
+		c.InstanceID = id
+		c.Output = out.String()
+	}
+}
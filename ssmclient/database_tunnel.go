@@ -0,0 +1,59 @@
+package ssmclient
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// hostLabelRe matches a single label of a DNS host name.
+var hostLabelRe = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// DatabaseTunnel forwards connections to localPort on the local host to dbPort on a database (or any other private
+// network service, like an RDS instance or ElastiCache cluster) reached through the bastionTarget managed instance.
+// The dbEndpoint is the host name (or IP address) of the database, as shown in the AWS console, without a scheme or
+// port.  If localPort is 0, the same port as dbPort is used, so database clients can use their usual port.  This
+// blocks until the session ends, the same as PortForwardingSession.  Use PortForwardingSession with the Host field
+// of PortForwardingInput to set any other session options.
+func DatabaseTunnel(cfg aws.Config, bastionTarget, dbEndpoint string, dbPort, localPort int) error {
+	if err := validateHost(dbEndpoint); err != nil {
+		return err
+	}
+
+	if dbPort < 1 || dbPort > 65535 {
+		return fmt.Errorf("invalid database port %d", dbPort)
+	}
+
+	if localPort < 1 {
+		localPort = dbPort
+	}
+
+	return PortForwardingSession(cfg, &PortForwardingInput{
+		Target:     bastionTarget,
+		Host:       dbEndpoint,
+		RemotePort: dbPort,
+		LocalPort:  localPort,
+	})
+}
+
+// validateHost checks that host is an IP address, or a valid DNS host name.  This catches common mistakes, like
+// including a URL scheme or port, before starting the session.
+func validateHost(host string) error {
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+
+	if len(host) < 1 || len(host) > 253 {
+		return fmt.Errorf("invalid host name '%s'", host)
+	}
+
+	for _, l := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if !hostLabelRe.MatchString(l) {
+			return fmt.Errorf("invalid host name '%s', must not include a scheme or port", host)
+		}
+	}
+	return nil
+}
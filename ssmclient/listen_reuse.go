@@ -0,0 +1,31 @@
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package ssmclient
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reuseControl returns a net.ListenConfig Control function which sets the SO_REUSEADDR and/or SO_REUSEPORT options
+// on the listening socket.
+func reuseControl(reuseAddr, reusePort bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if reuseAddr {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			}
+
+			if reusePort && sockErr == nil {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			}
+		})
+
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package ssmclient
+
+import "syscall"
+
+// reuseControl returns nil, the socket options are not supported (or, on Windows, SO_REUSEADDR allows other
+// processes to take over the port), so the listener is created with the default options.
+func reuseControl(_, _ bool) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,15 @@
+package ssmclient
+
+import "github.com/mmmorris1975/ssm-session-client/datachannel"
+
+// SetLogger sets the Logger used for the log messages written by this package, and the datachannel package.  A nil
+// Logger restores the default, which writes all messages using the standard library log package.  Use
+// datachannel.DiscardLogger to silence the log messages.
+func SetLogger(l datachannel.Logger) {
+	datachannel.SetLogger(l)
+}
+
+// logger returns the Logger set with SetLogger.
+func logger() datachannel.Logger {
+	return datachannel.CurrentLogger()
+}
@@ -0,0 +1,45 @@
+package ssmclient
+
+import (
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// SessionMetrics describes a completed session, and is passed to the MetricsSink of the SessionOptions when the
+// data channel for the session is closed.
+type SessionMetrics struct {
+	SessionID     string
+	Target        string
+	Mode          SessionMode
+	Start         time.Time
+	Duration      time.Duration
+	BytesSent     int64 // payload bytes sent to the session
+	BytesReceived int64 // payload bytes received from the session
+	Reconnects    int64 // reconnects after the websocket connection was dropped
+}
+
+// MetricsSink receives the metrics for each session started with SessionOptions which set it.  RecordSession is
+// called when the session's data channel is closed, in the goroutine closing it, so implementations which make
+// network calls should limit how long they take (see the cwmetrics package for a CloudWatch implementation).
+type MetricsSink interface {
+	RecordSession(SessionMetrics)
+}
+
+// recordMetrics configures the data channel to send the metrics for the session to the sink when it is closed.
+func recordMetrics(c *datachannel.SsmDataChannel, sink MetricsSink, target string, mode SessionMode) {
+	start := time.Now()
+	c.OnClose = func() {
+		stats := c.Stats()
+		sink.RecordSession(SessionMetrics{
+			SessionID:     c.SessionID(),
+			Target:        target,
+			Mode:          mode,
+			Start:         start,
+			Duration:      time.Since(start),
+			BytesSent:     stats.BytesSent,
+			BytesReceived: stats.BytesReceived,
+			Reconnects:    stats.Reconnects,
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package ssmclient
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// NonInteractiveCommandSession runs the command on the instance specified in the target parameter using the
+// AWS-StartNonInteractiveCommand session document, and streams the output of the command to stdout.  Unlike a
+// shell session, the command is not run in a terminal, and the local terminal is not used.  The agent ends the
+// session when the command exits, and nil is returned.  The exit code of the command is not reported to the client
+// by the agent for this session type, so a command which needs to report failure should do so in its output.
+func NonInteractiveCommandSession(cfg aws.Config, target string, command string) error {
+	return NonInteractiveCommandSessionWithInput(cfg, &ShellSessionInput{Target: target}, command, os.Stdout)
+}
+
+// NonInteractiveCommandSessionWithInput runs the command the same as NonInteractiveCommandSession, using the
+// ShellSessionInput parameters to configure the session, and writing the command output to out.  The DocumentName
+// and Parameters of the ShellSessionInput are replaced with the AWS-StartNonInteractiveCommand document and the
+// command, the Environment, WorkingDirectory, terminal and transcript settings are not used.
+func NonInteractiveCommandSessionWithInput(cfg aws.Config, opts *ShellSessionInput, command string,
+	out io.Writer) error {
+	if len(command) < 1 {
+		return errors.New("command is required")
+	}
+
+	in := *opts
+	in.DocumentName = nonInteractiveCommandDocument
+	in.Parameters = map[string][]string{"command": {command}}
+	in.Environment = nil
+	in.WorkingDirectory = ""
+
+	c := opts.Options.newDataChannel(opts.Target, NonInteractiveCommandMode)
+	if err := c.Open(cfg, shellStartSessionInput(&in)); err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// the data channel returns io.EOF when the agent closes the session, which io.Copy does not treat as an error
+	if _, err := io.Copy(out, c); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
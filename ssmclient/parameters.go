@@ -0,0 +1,67 @@
+package ssmclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParametersFromFile reads session document parameters from a JSON or YAML file, for use as the Parameters field
+// of ShellSessionInput.  The file must contain a single object (mapping) of parameter names, and each value can be
+// a list, the same as the StartSession API (and the AWS CLI --parameters option), or a single value.
+// Numbers and booleans are converted to strings, for example:
+//
+//	command: ["sudo -i"]
+//	workingDirectory: /opt/app
+//	timeout: 300
+func ParametersFromFile(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so a single parser handles both formats
+	raw := make(map[string]interface{})
+	if err = yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid parameters file %s: %v", path, err)
+	}
+
+	params := make(map[string][]string, len(raw))
+	for k, v := range raw {
+		switch t := v.(type) {
+		case []interface{}:
+			vals := make([]string, 0, len(t))
+			for _, e := range t {
+				s, err := parameterString(e)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for parameter %s: %v", k, err)
+				}
+				vals = append(vals, s)
+			}
+			params[k] = vals
+		case nil:
+			params[k] = []string{}
+		default:
+			s, err := parameterString(t)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for parameter %s: %v", k, err)
+			}
+			params[k] = []string{s}
+		}
+	}
+	return params, nil
+}
+
+// parameterString converts a scalar parameter value to a string.
+func parameterString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []interface{}, map[string]interface{}:
+		return "", errors.New("must be a single value or a list of values")
+	default:
+		return fmt.Sprint(t), nil
+	}
+}
@@ -0,0 +1,25 @@
+// +build js
+
+package ssmclient
+
+import (
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// ErrPluginUnsupported is the error returned by the plugin session functions on platforms where the session
+// manager plugin code can not be built (js/wasm).  Use the native session functions instead.
+var ErrPluginUnsupported = errors.New("session manager plugin is not supported on this platform")
+
+// PluginSession is not supported when running as WebAssembly, and always returns ErrPluginUnsupported.
+func PluginSession(aws.Config, *ssm.StartSessionInput) error {
+	return ErrPluginUnsupported
+}
+
+// PluginSessionWithLogWriter is not supported when running as WebAssembly, and always returns ErrPluginUnsupported.
+func PluginSessionWithLogWriter(aws.Config, *ssm.StartSessionInput, io.Writer) error {
+	return ErrPluginUnsupported
+}
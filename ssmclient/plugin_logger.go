@@ -0,0 +1,87 @@
+// +build !js
+
+package ssmclient
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	pluginlog "github.com/aws/session-manager-plugin/src/log"
+)
+
+// pluginLogger adapts the session manager plugin log.T interface to write messages at Info level and above to an
+// io.Writer, instead of the log files and console output used by the plugin's own logger.  Trace and Debug messages
+// are discarded, since the plugin logs every message sent and received at those levels.
+type pluginLogger struct {
+	l   *log.Logger
+	ctx string
+}
+
+func newPluginLogger(w io.Writer) *pluginLogger {
+	return &pluginLogger{l: log.New(w, "", log.LstdFlags)}
+}
+
+func (p *pluginLogger) output(level, msg string) {
+	_ = p.l.Output(3, fmt.Sprintf("%s %s%s", level, p.ctx, msg))
+}
+
+func (p *pluginLogger) Tracef(string, ...interface{}) {}
+
+func (p *pluginLogger) Debugf(string, ...interface{}) {}
+
+func (p *pluginLogger) Infof(format string, params ...interface{}) {
+	p.output("INFO", fmt.Sprintf(format, params...))
+}
+
+func (p *pluginLogger) Warnf(format string, params ...interface{}) error {
+	p.output("WARN", fmt.Sprintf(format, params...))
+	return nil
+}
+
+func (p *pluginLogger) Errorf(format string, params ...interface{}) error {
+	p.output("ERROR", fmt.Sprintf(format, params...))
+	return nil
+}
+
+func (p *pluginLogger) Criticalf(format string, params ...interface{}) error {
+	p.output("CRITICAL", fmt.Sprintf(format, params...))
+	return nil
+}
+
+func (p *pluginLogger) Trace(...interface{}) {}
+
+func (p *pluginLogger) Debug(...interface{}) {}
+
+func (p *pluginLogger) Info(v ...interface{}) {
+	p.output("INFO", fmt.Sprint(v...))
+}
+
+func (p *pluginLogger) Warn(v ...interface{}) error {
+	p.output("WARN", fmt.Sprint(v...))
+	return nil
+}
+
+func (p *pluginLogger) Error(v ...interface{}) error {
+	p.output("ERROR", fmt.Sprint(v...))
+	return nil
+}
+
+func (p *pluginLogger) Critical(v ...interface{}) error {
+	p.output("CRITICAL", fmt.Sprint(v...))
+	return nil
+}
+
+func (p *pluginLogger) Flush() {}
+
+func (p *pluginLogger) Close() {}
+
+// WithContext returns a logger which adds the context values to the beginning of each message.
+func (p *pluginLogger) WithContext(context ...string) pluginlog.T {
+	ctx := p.ctx
+	if len(context) > 0 {
+		ctx += strings.Join(context, " ") + " "
+	}
+	return &pluginLogger{l: p.l, ctx: ctx}
+}
@@ -1,10 +1,15 @@
+// +build !js
+
 package ssmclient
 
 import (
 	"context"
+	"errors"
+	"io"
 
 	"github.com/aws/session-manager-plugin/src/datachannel"
 	"github.com/aws/session-manager-plugin/src/log"
+	"github.com/aws/session-manager-plugin/src/sdkutil"
 	"github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session"
 	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/portsession"
 	_ "github.com/aws/session-manager-plugin/src/sessionmanagerplugin/session/shellsession"
@@ -13,25 +18,76 @@ import (
 	"github.com/google/uuid"
 )
 
+// PluginSession starts a session using the AWS session manager plugin code to handle the data channel.  The
+// StartSession API call is made using cfg, and the SSM endpoint and region used by the plugin are taken from cfg
+// (including any custom endpoint resolver) so that the plugin talks to the same service endpoint.
+//
+// The plugin makes its own ResumeSession and TerminateSession API calls using the AWS SDK for Go v1, which
+// only uses the default credential chain (environment variables, shared config/credentials files, instance
+// roles) and can not use the credentials provider, HTTP client, or middleware configured in cfg.  Sessions
+// which need those customizations should use the native (non-plugin) session functions.
 func PluginSession(cfg aws.Config, input *ssm.StartSessionInput) error {
-	out, err := ssm.NewFromConfig(cfg).StartSession(context.Background(), input)
+	return PluginSessionWithLogWriter(cfg, input, nil)
+}
+
+// PluginSessionWithLogWriter starts a session the same as PluginSession, writing the plugin's log messages (at
+// Info level and above) to w.  If w is nil, the plugin's default logging is used, which writes to log files in
+// a platform-specific location.
+func PluginSessionWithLogWriter(cfg aws.Config, input *ssm.StartSessionInput, w io.Writer) error {
+	out, err := ssm.NewFromConfig(clientConfig(cfg)).StartSession(context.Background(), input)
 	if err != nil {
 		return err
 	}
 
-	ep, err := ssm.NewDefaultEndpointResolver().ResolveEndpoint(cfg.Region, ssm.EndpointResolverOptions{})
+	ep, err := pluginEndpoint(cfg)
 	if err != nil {
 		return err
 	}
+	sdkutil.SetRegionAndProfile(cfg.Region, "")
 
 	ssmSession := new(session.Session)
 	ssmSession.SessionId = *out.SessionId
 	ssmSession.StreamUrl = *out.StreamUrl
 	ssmSession.TokenValue = *out.TokenValue
-	ssmSession.Endpoint = ep.URL
+	ssmSession.Endpoint = ep
 	ssmSession.ClientId = uuid.NewString()
 	ssmSession.TargetId = *input.Target
 	ssmSession.DataChannel = &datachannel.DataChannel{}
 
-	return ssmSession.Execute(log.Logger(false, ssmSession.ClientId))
+	var logger log.T = newPluginLogger(w)
+	if w == nil {
+		logger = log.Logger(false, ssmSession.ClientId)
+	}
+	return ssmSession.Execute(logger)
+}
+
+// pluginEndpoint returns the SSM endpoint URL for the region in cfg, using the endpoint resolvers configured
+// in cfg before falling back to the default SSM endpoint.
+func pluginEndpoint(cfg aws.Config) (string, error) {
+	var notFound *aws.EndpointNotFoundError
+
+	if r := cfg.EndpointResolverWithOptions; r != nil {
+		ep, err := r.ResolveEndpoint(ssm.ServiceID, cfg.Region)
+		if err == nil {
+			return ep.URL, nil
+		} else if !errors.As(err, &notFound) {
+			return "", err
+		}
+	}
+
+	//nolint:staticcheck // still honored by the SDK, so we will too
+	if r := cfg.EndpointResolver; r != nil {
+		ep, err := r.ResolveEndpoint(ssm.ServiceID, cfg.Region)
+		if err == nil {
+			return ep.URL, nil
+		} else if !errors.As(err, &notFound) {
+			return "", err
+		}
+	}
+
+	ep, err := ssm.NewDefaultEndpointResolver().ResolveEndpoint(cfg.Region, ssm.EndpointResolverOptions{})
+	if err != nil {
+		return "", err
+	}
+	return ep.URL, nil
 }
@@ -0,0 +1,143 @@
+package ssmclient
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnectionStats describes a connection to the local port of a port forwarding session (see
+// PortForwarder.Connections).
+// StreamID is the ID of the stream the connection is forwarded over in a multiplexed session, or 0 if the session
+// is not multiplexed.
+// ClientAddr is the address of the client which connected to the local port.
+// RemoteHost is the host the connection is forwarded to, the Host of the PortForwardingInput if set, otherwise the
+// Target instance.
+// RemotePort is the port on the RemoteHost the connection is forwarded to.
+// Started is the time the connection was accepted.
+// BytesSent is the number of bytes sent from the client to the remote port.
+// BytesReceived is the number of bytes received from the remote port and sent to the client.
+type ConnectionStats struct {
+	StreamID      uint32
+	ClientAddr    net.Addr
+	RemoteHost    string
+	RemotePort    int
+	Started       time.Time
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// connTracker records the active connections of a port forwarding session.  A nil connTracker doesn't record
+// anything, but the connections it returns still count their bytes.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[*trackedConn]struct{})}
+}
+
+// add records a new connection from the client, forwarded over the stream (0 if not multiplexed) to the remote
+// host and port of the session.
+func (t *connTracker) add(streamID uint32, client net.Addr, opts *PortForwardingInput) *trackedConn {
+	host := opts.Host
+	if len(host) < 1 {
+		host = opts.Target
+	}
+
+	tc := &trackedConn{
+		tracker: t,
+		stats: ConnectionStats{
+			StreamID:   streamID,
+			ClientAddr: client,
+			RemoteHost: host,
+			RemotePort: opts.RemotePort,
+			Started:    time.Now(),
+		},
+	}
+
+	if t != nil {
+		t.mu.Lock()
+		t.conns[tc] = struct{}{}
+		t.mu.Unlock()
+	}
+	return tc
+}
+
+// list returns the stats of the active connections, in the order they were accepted.
+func (t *connTracker) list() []ConnectionStats {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	stats := make([]ConnectionStats, 0, len(t.conns))
+	for tc := range t.conns {
+		stats = append(stats, tc.snapshot())
+	}
+	t.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Started.Equal(stats[j].Started) {
+			return stats[i].StreamID < stats[j].StreamID
+		}
+		return stats[i].Started.Before(stats[j].Started)
+	})
+	return stats
+}
+
+// trackedConn counts the bytes forwarded for a single connection.
+type trackedConn struct {
+	sent     int64
+	received int64
+	tracker  *connTracker
+	stats    ConnectionStats
+}
+
+// snapshot returns the current stats of the connection.
+func (c *trackedConn) snapshot() ConnectionStats {
+	s := c.stats
+	s.BytesSent = atomic.LoadInt64(&c.sent)
+	s.BytesReceived = atomic.LoadInt64(&c.received)
+	return s
+}
+
+// sender returns a Reader which counts the bytes read from r (the client) as sent.
+func (c *trackedConn) sender(r io.Reader) io.Reader {
+	return &statReader{Reader: r, n: &c.sent}
+}
+
+// receiver returns a Reader which counts the bytes read from r (the remote side) as received.
+func (c *trackedConn) receiver(r io.Reader) io.Reader {
+	return &statReader{Reader: r, n: &c.received}
+}
+
+// addReceived counts n bytes received from the remote side.
+func (c *trackedConn) addReceived(n int) {
+	atomic.AddInt64(&c.received, int64(n))
+}
+
+// done removes the connection from the active connections.
+func (c *trackedConn) done() {
+	if t := c.tracker; t != nil {
+		t.mu.Lock()
+		delete(t.conns, c)
+		t.mu.Unlock()
+	}
+}
+
+// statReader adds the number of bytes read from the Reader to n.
+type statReader struct {
+	io.Reader
+	n *int64
+}
+
+func (r *statReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,85 @@
+package ssmclient
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// PortForwarder is a handle for a port forwarding session running in the background, for applications (like GUIs)
+// which manage the session alongside their own event loop instead of dedicating a goroutine to it.
+type PortForwarder struct {
+	addr   net.Addr
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	conns  *connTracker
+}
+
+// StartPortForwarder starts a port forwarding session using the PortForwardingInput parameters, and returns once
+// the session handshake has completed and the local port is accepting connections.  Connections are handled in
+// the background until Stop() is called, or the session ends.  If the session ends before the local port is
+// listening, the error is returned instead.  Signals are not handled, so the caller should call Stop() when the
+// program is shutting down.
+func StartPortForwarder(cfg aws.Config, opts *PortForwardingInput) (*PortForwarder, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	in := *opts
+	in.conns = newConnTracker()
+
+	addr, errCh, err := StartPortForwardingSession(ctx, cfg, &in)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	p := &PortForwarder{addr: addr, cancel: cancel, done: make(chan struct{}), conns: in.conns}
+	go func() {
+		p.err = <-errCh
+		cancel()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// Addr returns the address of the local listener.
+func (p *PortForwarder) Addr() net.Addr {
+	return p.addr
+}
+
+// Connections returns the stats of the connections to the local port which are currently being forwarded, in the
+// order they were accepted.  Multiplexed sessions can forward many connections at once, each over its own stream,
+// other sessions forward at most 1 connection.  No connections are returned once the session has ended.
+func (p *PortForwarder) Connections() []ConnectionStats {
+	select {
+	case <-p.done:
+		return nil
+	default:
+		return p.conns.list()
+	}
+}
+
+// Done returns a channel which is closed when the session ends.
+func (p *PortForwarder) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the session ends, and returns the error which ended the session (context.Canceled if the
+// session was ended by Stop).  It is safe to call Wait from multiple goroutines.
+func (p *PortForwarder) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Stop terminates the session, closes the local listener and any forwarded connections, and waits for the session
+// to end.  The error is nil if the session was ended by Stop, otherwise it is the error which previously ended the
+// session.  Calling Stop more than once is safe.
+func (p *PortForwarder) Stop() error {
+	p.cancel()
+	if err := p.Wait(); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
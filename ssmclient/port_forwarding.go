@@ -1,13 +1,16 @@
 package ssmclient
 
 import (
+	"context"
+	"errors"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
@@ -15,80 +18,354 @@ import (
 	"golang.org/x/net/netutil"
 )
 
+// exitFunc is called to end the program after handling a shutdown signal.  Tests can replace it to verify
+// the teardown sequence without exiting the test binary.
+var exitFunc = os.Exit
+
+// ErrByteLimitReached is the error returned when a port forwarding session is ended because the number of bytes
+// transferred exceeded PortForwardingInput.MaxBytes.
+var ErrByteLimitReached = errors.New("session byte transfer limit reached")
+
+// terminateTimeout is how long to wait for the agent to acknowledge the end of a port forwarding session, before
+// closing the data channel anyway.
+const terminateTimeout = 2 * time.Second
+
 // PortForwardingInput configures the port forwarding session parameters.
-// Target is the EC2 instance ID to establish the session with.
-// RemotePort is the port on the EC2 instance to connect to.
-// LocalPort is the port on the local host to listen to.  If not provided, a random port will be used.
 type PortForwardingInput struct {
-	Target     string
+	// Target is the EC2 instance ID (or instance ARN) to establish the session with.
+	Target string
+	// RemotePort is the port on the EC2 instance to connect to.
 	RemotePort int
-	LocalPort  int
-	Host       string // optional
+	// LocalPort is the port on the local host to listen to.  If not provided, a random port will be used.
+	LocalPort int
+	// Host is an optional remote host name or IP address to forward connections to, through the target instance,
+	// instead of the target instance itself.  The instance must be able to reach RemotePort on the host.
+	Host string
+	// Reason is an optional justification for the session, which is recorded in the session history and CloudTrail.
+	Reason string
+	// TCPKeepAlivePeriod sets the TCP keepalive period for connections accepted on the local port, so that dead
+	// clients are detected and the connection closed.  If not provided, Go's default period (15 seconds) is used.  A
+	// negative value disables TCP keepalives.
+	TCPKeepAlivePeriod time.Duration
+	// OnReady is an optional function called once the session handshake has completed and the local port is
+	// listening, after which it is safe to connect to the local port.
+	OnReady func()
+	// ListenAddrCh is an optional channel which is sent the address of the local listener (useful to find the port
+	// used if LocalPort is not set) before accepting connections.  The send blocks, so the channel must be buffered,
+	// or be read from another goroutine.
+	ListenAddrCh chan<- net.Addr
+	// OnListen is an optional function called with the address of the local listener as soon as it is ready to
+	// accept connections.  Unlike ListenAddrCh, it doesn't need a reader.
+	OnListen func(net.Addr)
+	// MaxBytes, if greater than 0, is the limit of the total bytes sent and received over all connections to the
+	// local port.  Once the limit is exceeded, the session is terminated and ErrByteLimitReached is returned.
+	MaxBytes int64
+	// PluginLogWriter is an optional destination for the log messages of the session manager plugin, only used by
+	// PortPluginSession and SSHPluginSession.  If not provided, the plugin writes its own log files.
+	PluginLogWriter io.Writer
+	// ConnectionIdleTimeout, if greater than 0, closes a connection to the local port when no data has been sent or
+	// received for this long.  The session remains open, and accepts a new connection.
+	ConnectionIdleTimeout time.Duration
+	// ModifyStartSessionInput is an optional function called with the StartSession API input just before the
+	// session is started, to set fields not exposed by this library.  The caller is responsible for the input
+	// remaining valid for the type of session.
+	ModifyStartSessionInput func(*ssm.StartSessionInput)
+	// HalfCloseTimeout, if greater than 0, keeps delivering data from the remote side after the local client closes
+	// its side of the connection for writing (a TCP half-close), until no data has been received for this long.  The
+	// SSM port forwarding protocol can not signal a half-close, so the remote side only sees the connection close once
+	// the timeout expires.  Not used for multiplexed sessions.
+	HalfCloseTimeout time.Duration
+	// Multiplex requests stream multiplexing, which forwards each connection to the local port as a separate stream,
+	// so multiple connections can be active at the same time.  This requires SSM agent version 3.0.196.0 or later,
+	// older agents fall back to forwarding 1 connection at a time.
+	Multiplex bool
+	// DisconnectOnExit is only used by SSHSession, and sends DisconnectPort instead of TerminateSession when the
+	// session ends, so the SSH client's own connection teardown completes before the agent ends the session.
+	DisconnectOnExit bool
+	// ReuseAddr sets SO_REUSEADDR on the local listener, so a tunnel which restarts frequently can bind to a fixed
+	// LocalPort while connections from the previous listener are in TIME_WAIT.  Ignored on Windows, where the option
+	// allows other processes to take over the port.
+	ReuseAddr bool
+	// ReusePort sets SO_REUSEPORT on the local listener (on systems which support it), allowing the LocalPort to be
+	// bound even if another socket, possibly in another process, is bound to it.  Only use this if sharing the port
+	// is intended.  The listen backlog is not configurable, Go uses the system's maximum (somaxconn on Linux).
+	ReusePort bool
+	// AutoLocalPort falls back to a random local port if the listener can't be created on LocalPort (usually because
+	// the port is in use), instead of returning an error.  Use OnListen or ListenAddrCh to find the port used.
+	AutoLocalPort bool
+	// Options configures the data channel used by the native session functions (see SessionOptions).
+	Options *SessionOptions
+
+	// conns records the active connections, if set (see PortForwarder.Connections)
+	conns *connTracker
 }
 
 // PortForwardingSession starts a port forwarding session using the PortForwardingInput parameters to
 // configure the session.  The aws.Config parameter will be used to call the AWS SSM StartSession
-// API, which is used as part of establishing the websocket communication channel.
-//
-//nolint:funlen,gocognit // it's long, but not overly hard to read despite what the gocognit says
+// API, which is used as part of establishing the websocket communication channel.  An interrupt or termination
+// signal ends the session, and exits the program once the session is terminated.
 func PortForwardingSession(cfg aws.Config, opts *PortForwardingInput) error {
+	return runUntilSignal(func(ctx context.Context) error {
+		return PortForwardingSessionWithContext(ctx, cfg, opts)
+	})
+}
+
+// PortForwardingSessionByName resolves the target spec to an instance ID using ResolveTarget, then starts a port
+// forwarding session with the instance the same as PortForwardingSession, forwarding localPort to remotePort.  The
+// resolved instance ID is logged when the session starts, and returned with the result of the session (or with the
+// error if the target could not be resolved, in which case the ID is empty).
+func PortForwardingSessionByName(cfg aws.Config, spec string, remotePort, localPort int) (string, error) {
+	id, err := ResolveTarget(spec, cfg)
+	if err != nil {
+		return "", err
+	}
+	logger().Infof("resolved target %s to %s", spec, id)
+
+	return id, PortForwardingSession(cfg, &PortForwardingInput{
+		Target:     id,
+		RemotePort: remotePort,
+		LocalPort:  localPort,
+	})
+}
+
+// StartPortForwardingSession starts a port forwarding session the same as PortForwardingSessionWithContext, but
+// returns as soon as the local port is listening, with the address of the listener (useful to find the port used if
+// LocalPort is not set).  The session runs in the background until the context is cancelled or the session ends,
+// then the result of the session is sent to the returned channel, which is then closed.  If the session ends before
+// the local port is listening, the error is returned instead.
+func StartPortForwardingSession(ctx context.Context, cfg aws.Config, opts *PortForwardingInput) (net.Addr,
+	<-chan error, error) {
+	addrCh := make(chan net.Addr, 1)
+	in := *opts
+	in.OnListen = func(addr net.Addr) {
+		addrCh <- addr
+		if opts.OnListen != nil {
+			opts.OnListen(addr)
+		}
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- PortForwardingSessionWithContext(ctx, cfg, &in)
+		close(errCh)
+	}()
+
+	select {
+	case addr := <-addrCh:
+		return addr, errCh, nil
+	case err := <-errCh:
+		// the session may have ended right after the listener started
+		select {
+		case addr := <-addrCh:
+			ch := make(chan error, 1)
+			ch <- err
+			close(ch)
+			return addr, ch, nil
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("port forwarding session ended before listening")
+		}
+		return nil, nil, err
+	}
+}
+
+// PortForwardingSessionWithContext starts a port forwarding session the same as PortForwardingSession, which
+// runs until the provided context is cancelled.  On cancellation, the session is terminated, the local listener
+// and any forwarded connection are closed, and the context error is returned.  Signals are not handled, so the
+// program embedding the session decides how to shut down (usually by cancelling the context).
+//
+//nolint:funlen,gocognit,gocyclo // it's long, but not overly hard to read despite what the gocognit says
+func PortForwardingSessionWithContext(ctx context.Context, cfg aws.Config, opts *PortForwardingInput) error {
 	c, err := openDataChannel(cfg, opts)
 	if err != nil {
 		return err
 	}
+
+	// the agent's acknowledgement of TerminateSession is only processed while messages are being read, so the
+	// session only waits for it (before closing the data channel) once the reader has started
+	var reading int32
+	terminate := func() {
+		if atomic.LoadInt32(&reading) < 1 {
+			_ = c.SendControlFlag(datachannel.TerminateSession)
+			return
+		}
+
+		timeout := c.TerminateTimeout
+		if timeout <= 0 {
+			timeout = terminateTimeout
+		}
+		_ = c.TerminateSessionAndWait(timeout)
+	}
+
 	defer func() {
 		// Both the basic and muxing plugins support TerminateSession on the agent side.
-		_ = c.TerminateSession()
+		terminate()
 		_ = c.Close()
 	}()
 
-	// use a signal handler vs. defer since defer operates after an escape from the outer loop
-	// and we can't trust the data channel connection state at that point.  Intercepting signals
-	// means we're probably trying to shutdown somewhere in the outer loop, and there's a good
-	// possibility that the data channel is still valid
-	installSignalHandler(c)
+	// closing stopCh signals the background goroutines to exit.  This is deferred after the data channel cleanup,
+	// so it runs first, and any goroutine blocked reading the data channel will be released when it's closed.
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	// cancelling the context closes the data channel, which releases anything blocked reading from it
+	go func() {
+		select {
+		case <-ctx.Done():
+			terminate()
+			_ = c.Close()
+		case <-stopCh:
+		}
+	}()
 
 	if err = c.WaitForHandshakeComplete(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return err
 	}
 
-	lsnr, err := createListener(opts.LocalPort)
+	info := c.SessionInfo()
+	if opts.Multiplex && !info.Multiplexing {
+		logger().Warnf("agent version %s does not support multiplexing, forwarding 1 connection at a time",
+			info.AgentVersion)
+	}
+
+	// multiplexed sessions forward each connection as a separate stream, so there's no need to limit connections
+	maxConns := 1
+	if info.Multiplexing {
+		maxConns = 0
+	}
+
+	lsnr, err := createListener(opts, maxConns)
 	if err != nil {
 		return err
 	}
 	defer lsnr.Close()
-	log.Printf("listening on %s", lsnr.Addr())
+	logger().Infof("listening on %s", lsnr.Addr())
+
+	// cancelling the context also closes the listener, so a pending Accept() returns
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = lsnr.Close()
+		case <-stopCh:
+		}
+	}()
+
+	if opts.OnListen != nil {
+		opts.OnListen(lsnr.Addr())
+	}
+
+	if opts.ListenAddrCh != nil {
+		opts.ListenAddrCh <- lsnr.Addr()
+	}
+
+	if opts.OnReady != nil {
+		opts.OnReady()
+	}
+
+	atomic.StoreInt32(&reading, 1)
+	if info.Multiplexing {
+		return muxPortForwarding(ctx, c, info, lsnr, opts, stopCh)
+	}
 
 	doneCh := make(chan bool)
 	errCh := make(chan error)
-	inCh := messageChannel(c, errCh)
+	inCh := messageChannel(c, errCh, stopCh)
+
+	counter := &byteCounter{limit: opts.MaxBytes}
+	var sessionErr error
+
+	var idleTicker *time.Ticker
+	defer func() {
+		if idleTicker != nil {
+			idleTicker.Stop()
+		}
+	}()
 
 outer:
 	for {
-		var conn net.Conn
-		conn, err = lsnr.Accept()
+		var lc net.Conn
+		lc, err = lsnr.Accept()
 		if err != nil {
+			if ctx.Err() != nil {
+				sessionErr = ctx.Err()
+				break outer
+			}
+
 			// not fatal, just wait for next (maybe unless lsnr is dead?)
-			log.Print(err)
+			logger().Warnf("%v", err)
 			continue
 		}
 
+		conn := &idleConn{Conn: lc}
+		conn.touch()
+		tc := opts.conns.add(0, lc.RemoteAddr(), opts)
+
+		var idleCh <-chan time.Time
+		if opts.ConnectionIdleTimeout > 0 {
+			if idleTicker != nil {
+				idleTicker.Stop()
+			}
+			idleTicker = time.NewTicker(idleCheckInterval(opts.ConnectionIdleTimeout))
+			idleCh = idleTicker.C
+		}
+
+		// set after the local client closes its side of the connection, while waiting for remote data to stop
+		var drainTimer *time.Timer
+		var drainCh <-chan time.Time
+
 		go func() {
 			// handle incoming messages from AWS in the background
-			if _, e := io.Copy(c, conn); e != nil {
-				errCh <- e
+			if _, e := io.Copy(c, &countingReader{tc.sender(conn), counter}); e != nil {
+				// errors end the connection in the inner loop, so doneCh must not be sent as well, otherwise
+				// it would be received while handling the next connection
+				select {
+				case errCh <- e:
+				case <-stopCh:
+				}
+				return
+			}
+
+			select {
+			case doneCh <- true:
+			case <-stopCh:
 			}
-			doneCh <- true
 		}()
 
 	inner:
 		for {
 			select {
+			case <-ctx.Done():
+				sessionErr = ctx.Err()
+				_ = conn.Close()
+				break outer
+			case <-idleCh:
+				if conn.idle() >= opts.ConnectionIdleTimeout {
+					// closing the connection will end the io.Copy() goroutine, which ends this loop
+					logger().Infof("closing connection idle for %s", opts.ConnectionIdleTimeout)
+					_ = c.DisconnectPort()
+					_ = conn.Close()
+					idleCh = nil
+				}
 			case <-doneCh:
+				if opts.HalfCloseTimeout > 0 {
+					// local side is done sending (read EOF), keep delivering remote data until it stops
+					drainTimer = time.NewTimer(opts.HalfCloseTimeout)
+					drainCh = drainTimer.C
+					continue
+				}
+
 				// basic (non-muxing) connections support DisconnectPort to signal to the remote agent that
 				// we are shutting down this particular connection on our end, and possibly expect a new one.
 				_ = c.DisconnectPort()
 				break inner
+			case <-drainCh:
+				_ = c.DisconnectPort()
+				break inner
 			case data, ok := <-inCh:
 				if !ok {
 					// incoming websocket channel is closed, which is fatal
@@ -97,32 +374,128 @@ outer:
 				}
 
 				if _, err = conn.Write(data); err != nil {
-					log.Print(err)
+					logger().Warnf("%v", err)
+				}
+				tc.addReceived(len(data))
+
+				if drainTimer != nil {
+					drainTimer.Reset(opts.HalfCloseTimeout)
+				}
+
+				if sessionErr = counter.add(len(data)); sessionErr != nil {
+					_ = conn.Close()
+					break outer
 				}
 			case er, ok := <-errCh:
 				if !ok {
 					// I can't think of a good reason why we'd ever end up here, but if we do
 					// we should stop the world
-					log.Print("errCh closed")
+					logger().Errorf("errCh closed")
 					_ = conn.Close()
 					break outer
 				}
 
 				// any write to errCh means at least 1 of the goroutines has exited
-				log.Print(er)
+				logger().Warnf("%v", er)
+				if errors.Is(er, ErrByteLimitReached) {
+					sessionErr = er
+					_ = conn.Close()
+					break outer
+				}
 				break inner
 			}
 		}
 
+		if drainTimer != nil {
+			drainTimer.Stop()
+		}
 		_ = conn.Close()
+		tc.done()
+	}
+
+	// cancellation closes the data channel, so the loop may have exited from the closed channel instead
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return sessionErr
+}
+
+// idleCheckInterval returns how often to check a connection against the idle timeout.
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	if d := timeout / 4; d > 0 {
+		return d
+	}
+	return timeout
+}
+
+// idleConn records the time of the last successful read or write on the connection.
+type idleConn struct {
+	net.Conn
+	last int64
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *idleConn) touch() {
+	atomic.StoreInt64(&c.last, time.Now().UnixNano())
+}
+
+// idle returns the amount of time since the last read or write.
+func (c *idleConn) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.last)))
+}
+
+// byteCounter tracks the total number of bytes transferred in a session, a limit of 0 means unlimited.
+type byteCounter struct {
+	n     int64
+	limit int64
+}
+
+func (b *byteCounter) add(n int) error {
+	if atomic.AddInt64(&b.n, int64(n)) > b.limit && b.limit > 0 {
+		return ErrByteLimitReached
 	}
 	return nil
 }
 
+// countingReader adds the bytes read from the Reader to the byteCounter, and fails the read once the limit is exceeded.
+type countingReader struct {
+	io.Reader
+	counter *byteCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if e := r.counter.add(n); e != nil {
+		return n, e
+	}
+	return n, err
+}
+
 // PortPluginSession delegates the execution of the SSM port forwarding to the AWS-managed session manager plugin code,
 // bypassing this libraries internal websocket code and connection management.
 func PortPluginSession(cfg aws.Config, opts *PortForwardingInput) error {
-	documentName := "AWS-StartPortForwardingSession"
+	return PluginSessionWithLogWriter(cfg, portStartSessionInput(opts), opts.PluginLogWriter)
+}
+
+// portStartSessionInput returns the StartSession API input for a port forwarding session, forwarding to the
+// RemotePort on the target instance, or on the remote Host (reached through the target instance) if set.
+func portStartSessionInput(opts *PortForwardingInput) *ssm.StartSessionInput {
+	documentName := portForwardingDocument
 	parameters := map[string][]string{
 		"localPortNumber": {strconv.Itoa(opts.LocalPort)},
 		"portNumber":      {strconv.Itoa(opts.RemotePort)},
@@ -130,37 +503,37 @@ func PortPluginSession(cfg aws.Config, opts *PortForwardingInput) error {
 
 	if opts.Host != "" {
 		parameters["host"] = []string{opts.Host}
-		documentName = "AWS-StartPortForwardingSessionToRemoteHost"
+		documentName = remoteHostPortForwardingDocument
 	}
 
 	in := &ssm.StartSessionInput{
 		DocumentName: aws.String(documentName),
-		Target:       aws.String(opts.Target),
+		Target:       sessionTarget(opts.Target),
 		Parameters:   parameters,
+		Reason:       stringOrNil(opts.Reason),
 	}
-
-	return PluginSession(cfg, in)
+	return modifyInput(in, opts.ModifyStartSessionInput)
 }
 
 func openDataChannel(cfg aws.Config, opts *PortForwardingInput) (*datachannel.SsmDataChannel, error) {
-	in := &ssm.StartSessionInput{
-		DocumentName: aws.String("AWS-StartPortForwardingSession"),
-		Target:       aws.String(opts.Target),
-		Parameters: map[string][]string{
-			"localPortNumber": {strconv.Itoa(opts.LocalPort)},
-			"portNumber":      {strconv.Itoa(opts.RemotePort)},
-		},
+	mode := PortForwardMode
+	if opts.Host != "" {
+		mode = RemoteHostPortForwardMode
 	}
 
-	c := new(datachannel.SsmDataChannel)
-	if err := c.Open(cfg, in); err != nil {
+	c := opts.Options.newDataChannel(opts.Target, mode)
+	c.Multiplex = opts.Multiplex
+	if err := c.Open(cfg, portStartSessionInput(opts)); err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-// read messages from websocket and write payload to the returned channel.
-func messageChannel(c datachannel.DataChannel, errCh chan error) chan []byte {
+// read messages from websocket and write payload to the returned channel.  The goroutine reading the messages
+// exits when the data channel returns an error.  Once stopCh is closed, payloads are discarded instead of being sent
+// to the channel, but messages are still read, so the acknowledgement of TerminateSession is received while the
+// session ends.  The data channel must be closed to guarantee the goroutine exits.
+func messageChannel(c datachannel.DataChannel, errCh chan error, stopCh <-chan struct{}) chan []byte {
 	inCh := make(chan []byte)
 
 	buf := make([]byte, 4096)
@@ -169,21 +542,35 @@ func messageChannel(c datachannel.DataChannel, errCh chan error) chan []byte {
 	go func() {
 		defer close(inCh)
 
+		// deliver any output received before the handshake completed first
+		if p, ok := c.(interface{ PendingOutput() []byte }); ok {
+			if early := p.PendingOutput(); len(early) > 0 {
+				select {
+				case inCh <- early:
+				case <-stopCh:
+				}
+			}
+		}
+
 		for {
 			nr, err := c.Read(buf)
-			if err != nil {
-				errCh <- err
-				return
+			if err == nil {
+				payload, err = c.HandleMsg(buf[:nr])
 			}
 
-			payload, err = c.HandleMsg(buf[:nr])
 			if err != nil {
-				errCh <- err
+				select {
+				case errCh <- err:
+				case <-stopCh:
+				}
 				return
 			}
 
 			if len(payload) > 0 {
-				inCh <- payload
+				select {
+				case inCh <- payload:
+				case <-stopCh:
+				}
 			}
 		}
 	}()
@@ -191,28 +578,100 @@ func messageChannel(c datachannel.DataChannel, errCh chan error) chan []byte {
 	return inCh
 }
 
-func createListener(port int) (net.Listener, error) {
-	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+// the keepAlive period is applied to connections accepted by the listener, 0 uses the Go default (enabled)
+// and a negative value disables keepalives.  The number of simultaneous connections is limited to maxConns,
+// 0 means no limit.
+func createListener(opts *PortForwardingInput, maxConns int) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: opts.TCPKeepAlivePeriod}
+	if opts.ReuseAddr || opts.ReusePort {
+		lc.Control = reuseControl(opts.ReuseAddr, opts.ReusePort)
+	}
+
+	l, err := lc.Listen(context.Background(), "tcp", net.JoinHostPort("", strconv.Itoa(opts.LocalPort)))
+	if err != nil && opts.AutoLocalPort && opts.LocalPort > 0 {
+		logger().Warnf("unable to listen on port %d, using a random port: %v", opts.LocalPort, err)
+		l, err = lc.Listen(context.Background(), "tcp", net.JoinHostPort("", "0"))
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	// use limit listener for now, eventually maybe we'll add muxing
+	// The basic (non-muxing) port protocol forwards a single stream to a single connection on the agent side, so
+	// the data of concurrent local connections would be interleaved.  Accepting more than 1 connection at a time
+	// needs stream muxing (see PortForwardingInput.Multiplex).
 	// REF: https://github.com/aws/amazon-ssm-agent/blob/master/agent/session/plugins/port/port_mux.go
-	return netutil.LimitListener(l, 1), nil
+	if maxConns < 1 {
+		return l, nil
+	}
+	return netutil.LimitListener(l, maxConns), nil
+}
+
+// clientConfig returns a copy of cfg which identifies this library in the User-Agent header of AWS API calls.
+func clientConfig(cfg aws.Config) aws.Config {
+	return datachannel.WithUserAgent(cfg, datachannel.UserAgentName, datachannel.Version)
+}
+
+// modifyInput calls the function f, if set, to modify the StartSession API input.
+func modifyInput(in *ssm.StartSessionInput, f func(*ssm.StartSessionInput)) *ssm.StartSessionInput {
+	if f != nil {
+		f(in)
+	}
+	return in
+}
+
+// stringOrNil returns nil for an empty string, so that optional API parameters are omitted from the request.
+func stringOrNil(s string) *string {
+	if len(s) < 1 {
+		return nil
+	}
+	return aws.String(s)
+}
+
+// runUntilSignal calls f with a context which is cancelled when an interrupt or termination signal is received.  If
+// that happens, the program exits once f returns, so the session is terminated cleanly before exiting.  The signal
+// handler is removed when f returns.
+func runUntilSignal(f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	gotSig := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigCh:
+			logger().Infof("Got signal: %s, shutting down", sig.String())
+			close(gotSig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := f(ctx)
+
+	select {
+	case <-gotSig:
+		exitFunc(0)
+	default:
+	}
+	return err
 }
 
-// shared with ssh.go.
+// installSignalHandler terminates the session and exits the program when an interrupt or termination signal is
+// received, used by SSHSession.
 func installSignalHandler(c datachannel.DataChannel) {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigCh
-		log.Printf("Got signal: %s, shutting down", sig.String())
+		logger().Infof("Got signal: %s, shutting down", sig.String())
 
 		_ = c.TerminateSession()
 		_ = c.Close()
 
-		os.Exit(0)
+		exitFunc(0)
 	}()
 }
@@ -0,0 +1,75 @@
+package ssmclient
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// PortForwardingResult is the outcome of starting 1 of the sessions passed to StartPortForwardingSessions.  Index
+// is the position of the session's input in the list passed to StartPortForwardingSessions.  If Err is nil, the
+// session is running, Addr is the address of its local listener, and the result of the session is sent to Done
+// when it ends (see StartPortForwardingSession).
+type PortForwardingResult struct {
+	Index int
+	Input *PortForwardingInput
+	Addr  net.Addr
+	Done  <-chan error
+	Err   error
+}
+
+// StartPortForwardingSessions starts a port forwarding session for each of the inputs, using
+// StartPortForwardingSession, with at most maxConcurrent sessions being started at once, so large numbers of
+// sessions don't trip the SSM API rate limits or overwhelm the local machine.  The remaining sessions are queued,
+// and started as earlier sessions finish starting (whether or not they succeed).  A maxConcurrent less than 1
+// starts all the sessions at once.  Signals are not handled, so the caller should cancel the context when the
+// program is shutting down.
+//
+// The result of starting each session is sent to the returned channel as soon as it is known, so results are not
+// in the same order as the inputs, and the channel is closed once every session has been started or has failed.
+// The channel is buffered for all the results, so it doesn't need to be read for the sessions to start.  Sessions
+// which have not started when the context is cancelled fail with the context error, and running sessions end.
+func StartPortForwardingSessions(ctx context.Context, cfg aws.Config, inputs []*PortForwardingInput,
+	maxConcurrent int) <-chan PortForwardingResult {
+	results := make(chan PortForwardingResult, len(inputs))
+
+	if maxConcurrent < 1 || maxConcurrent > len(inputs) {
+		maxConcurrent = len(inputs)
+	}
+	sem := make(chan struct{}, maxConcurrent)
+
+	var wg sync.WaitGroup
+	wg.Add(len(inputs))
+
+	go func() {
+		for i, in := range inputs {
+			r := PortForwardingResult{Index: i, Input: in}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				r.Err = ctx.Err()
+				results <- r
+				wg.Done()
+				continue
+			}
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				if r.Err = ctx.Err(); r.Err == nil {
+					r.Addr, r.Done, r.Err = StartPortForwardingSession(ctx, cfg, r.Input)
+				}
+				results <- r
+			}()
+		}
+
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,126 @@
+package ssmclient
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// fakeDataChannel is a datachannel.DataChannel which returns the messages sent to msgs from Read, until it is closed.
+// HandleMsg returns the message as the payload.  Calling any other DataChannel method panics.
+type fakeDataChannel struct {
+	datachannel.DataChannel
+	msgs    chan []byte
+	closed  chan struct{}
+	once    sync.Once
+	pending []byte
+}
+
+func newFakeDataChannel(pending string) *fakeDataChannel {
+	return &fakeDataChannel{msgs: make(chan []byte), closed: make(chan struct{}), pending: []byte(pending)}
+}
+
+func (f *fakeDataChannel) Read(p []byte) (int, error) {
+	select {
+	case m := <-f.msgs:
+		return copy(p, m), nil
+	case <-f.closed:
+		return 0, io.EOF
+	}
+}
+
+func (f *fakeDataChannel) HandleMsg(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+func (f *fakeDataChannel) PendingOutput() []byte {
+	return f.pending
+}
+
+func (f *fakeDataChannel) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+// send delivers the message to the next Read, failing the test if nothing is reading from the data channel.
+func (f *fakeDataChannel) send(t *testing.T, msg string) {
+	t.Helper()
+
+	select {
+	case f.msgs <- []byte(msg):
+	case <-time.After(time.Second):
+		t.Fatalf("message %q was not read from the data channel", msg)
+	}
+}
+
+func TestMessageChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending string   // output received before the handshake completed
+		before  []string // messages received from the channel before stopCh is closed
+		after   []string // messages which must still be read (and discarded) after stopCh is closed
+		readErr bool     // the data channel fails before stopCh is closed, and nothing receives the error
+	}{
+		{name: "no messages"},
+		{name: "pending output first", pending: "early", before: []string{"late"}},
+		{name: "stopped while idle", before: []string{"a", "b"}},
+		{name: "read after stop", before: []string{"a"}, after: []string{"ack", "ack", "ack"}},
+		{name: "error not received", before: []string{"a"}, readErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeDataChannel(tc.pending)
+			errCh := make(chan error)
+			stopCh := make(chan struct{})
+
+			inCh := messageChannel(c, errCh, stopCh)
+
+			want := tc.before
+			if len(tc.pending) > 0 {
+				want = append([]string{tc.pending}, want...)
+			}
+
+			for i, msg := range want {
+				if i > 0 || len(tc.pending) < 1 {
+					c.send(t, msg)
+				}
+
+				select {
+				case got := <-inCh:
+					if string(got) != msg {
+						t.Errorf("got payload %q, want %q", got, msg)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("payload %q was not delivered", msg)
+				}
+			}
+
+			if tc.readErr {
+				_ = c.Close()
+			}
+			close(stopCh)
+
+			for _, msg := range tc.after {
+				c.send(t, msg)
+			}
+			_ = c.Close()
+
+			// the goroutine closes inCh when it exits
+			timeout := time.After(time.Second)
+			for {
+				select {
+				case _, ok := <-inCh:
+					if !ok {
+						return
+					}
+				case <-timeout:
+					t.Fatal("message channel goroutine did not exit")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package ssmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+	"github.com/xtaci/smux"
+)
+
+// muxKeepAliveAgentVersion is the first agent version which expects smux keepalives to be disabled.  The keepalive
+// messages count as session activity, and would otherwise prevent the session idle timeout from ever expiring.
+// REF: https://github.com/aws/session-manager-plugin/blob/mainline/src/config/config.go
+const muxKeepAliveAgentVersion = "3.1.1511.1"
+
+// muxPortForwarding handles the connections to the local listener for a multiplexed port forwarding session.  Each
+// connection is forwarded over its own smux stream, so any number of connections can be active at the same time.
+//
+//nolint:funlen // mostly setup, the work is done in muxTransfer
+func muxPortForwarding(ctx context.Context, c datachannel.DataChannel, agent datachannel.SessionInfo,
+	lsnr net.Listener, opts *PortForwardingInput, stopCh <-chan struct{}) error {
+	// failCh receives the error which ends the session (nil if the agent ended it), closing the listener
+	// releases the accept loop so it can be returned
+	failCh := make(chan error, 1)
+	var failOnce sync.Once
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failCh <- err
+			_ = lsnr.Close()
+		})
+	}
+
+	errCh := make(chan error)
+	conn := &muxConn{c: c, inCh: messageChannel(c, errCh, stopCh), errCh: errCh, done: fail}
+
+	cfg := smux.DefaultConfig()
+	cfg.KeepAliveDisabled = agent.AgentVersionAtLeast(muxKeepAliveAgentVersion)
+
+	sess, err := smux.Client(conn, cfg)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	counter := &byteCounter{limit: opts.MaxBytes}
+
+	for {
+		lc, err := lsnr.Accept()
+		if err != nil {
+			select {
+			case err = <-failCh:
+				return err
+			default:
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// not fatal, just wait for next
+			logger().Warnf("%v", err)
+			continue
+		}
+
+		stream, err := sess.OpenStream()
+		if err != nil {
+			_ = lc.Close()
+			return err
+		}
+
+		tc := opts.conns.add(stream.ID(), lc.RemoteAddr(), opts)
+		go muxTransfer(stream, lc, tc, counter, opts.ConnectionIdleTimeout, fail)
+	}
+}
+
+// muxTransfer copies data between the local connection and the smux stream, until either side is closed, the
+// connection is idle for longer than idleTimeout (if greater than 0), or the session byte limit is reached.  The
+// bytes copied in each direction are counted by tc.
+func muxTransfer(stream io.ReadWriteCloser, lc net.Conn, tc *trackedConn, counter *byteCounter,
+	idleTimeout time.Duration, fail func(error)) {
+	conn := &idleConn{Conn: lc}
+	conn.touch()
+
+	defer tc.done()
+	defer stream.Close()
+	defer conn.Close()
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, &countingReader{tc.sender(conn), counter})
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, &countingReader{tc.receiver(stream), counter})
+		errc <- err
+	}()
+
+	var idleCh <-chan time.Time
+	if idleTimeout > 0 {
+		t := time.NewTicker(idleCheckInterval(idleTimeout))
+		defer t.Stop()
+		idleCh = t.C
+	}
+
+	for {
+		select {
+		case err := <-errc:
+			if errors.Is(err, ErrByteLimitReached) {
+				fail(err)
+			}
+			return
+		case <-idleCh:
+			if conn.idle() >= idleTimeout {
+				logger().Infof("closing connection idle for %s", idleTimeout)
+				return
+			}
+		}
+	}
+}
+
+// muxConn adapts the data channel to the connection used by the smux client.  Reads return the payload of the
+// messages received from the agent, and writes are sent to the agent as data messages.  The done function is
+// called with the error which ended the data channel, or nil if the agent closed it.
+type muxConn struct {
+	c     datachannel.DataChannel
+	inCh  <-chan []byte
+	errCh chan error
+	done  func(error)
+	buf   []byte
+}
+
+func (m *muxConn) Read(p []byte) (int, error) {
+	if len(m.buf) < 1 {
+		select {
+		case data, ok := <-m.inCh:
+			if !ok {
+				m.done(nil)
+				return 0, io.EOF
+			}
+			m.buf = data
+		case err := <-m.errCh:
+			m.done(err)
+			return 0, err
+		}
+	}
+
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+func (m *muxConn) Write(p []byte) (int, error) {
+	return m.c.Write(p)
+}
+
+// Close is a no-op, the data channel is closed when the port forwarding session ends.
+func (m *muxConn) Close() error {
+	return nil
+}
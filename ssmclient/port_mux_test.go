@@ -0,0 +1,209 @@
+package ssmclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+	"github.com/xtaci/smux"
+)
+
+// pipeDataChannel is a datachannel.DataChannel which sends and receives data over conn as is, without the agent
+// message framing, so the smux session at the other end of conn acts as the agent.  Calling any other DataChannel
+// method panics.
+type pipeDataChannel struct {
+	datachannel.DataChannel
+	conn net.Conn
+}
+
+func (p *pipeDataChannel) Read(b []byte) (int, error) {
+	return p.conn.Read(b)
+}
+
+func (p *pipeDataChannel) Write(b []byte) (int, error) {
+	return p.conn.Write(b)
+}
+
+func (p *pipeDataChannel) HandleMsg(data []byte) ([]byte, error) {
+	return append([]byte(nil), data...), nil
+}
+
+func (p *pipeDataChannel) PendingOutput() []byte {
+	return nil
+}
+
+func (p *pipeDataChannel) Close() error {
+	return p.conn.Close()
+}
+
+// echoAgent accepts the streams opened by the client, and writes back everything read from each of them.
+func echoAgent(sess *smux.Session) {
+	for {
+		s, err := sess.AcceptStream()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer s.Close()
+			_, _ = io.Copy(s, s)
+		}()
+	}
+}
+
+// echoConn sends data over a new connection to addr, and checks the same data is received back.  The connection stays
+// open until release is closed, echoed is marked done once the data is received (or the connection fails).
+func echoConn(addr string, data []byte, echoed *sync.WaitGroup, release <-chan struct{}) error {
+	var once sync.Once
+	defer once.Do(echoed.Done)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// written in the background, so a large write doesn't block the echo being read
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		writeErr <- err
+	}()
+
+	got := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		return err
+	}
+
+	if err = <-writeErr; err != nil {
+		return err
+	}
+
+	if !bytes.Equal(got, data) {
+		return errors.New("received data does not match the data sent")
+	}
+
+	once.Do(echoed.Done)
+	<-release
+	return nil
+}
+
+func TestMuxPortForwarding(t *testing.T) {
+	tests := []struct {
+		name  string
+		conns int // concurrent connections to the local listener
+		size  int // bytes sent, and echoed, over each connection
+	}{
+		{name: "single connection", conns: 1, size: 10},
+		{name: "concurrent connections", conns: 8, size: 1000},
+		{name: "larger than a frame", conns: 3, size: 3*smux.DefaultConfig().MaxFrameSize + 1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer local.Close()
+
+			agent, err := smux.Server(remote, smux.DefaultConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer agent.Close()
+			go echoAgent(agent)
+
+			lsnr, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer lsnr.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+
+			opts := &PortForwardingInput{Target: "i-0123456789abcdef0", RemotePort: 5432, conns: newConnTracker()}
+
+			doneCh := make(chan error, 1)
+			go func() {
+				doneCh <- muxPortForwarding(ctx, &pipeDataChannel{conn: local}, datachannel.SessionInfo{}, lsnr, opts,
+					stopCh)
+			}()
+
+			echoed := new(sync.WaitGroup)
+			echoed.Add(tc.conns)
+			release := make(chan struct{})
+			results := make(chan error, tc.conns)
+
+			for i := 0; i < tc.conns; i++ {
+				data := bytes.Repeat([]byte{byte(i)}, tc.size)
+				go func() {
+					results <- echoConn(lsnr.Addr().String(), data, echoed, release)
+				}()
+			}
+
+			waitCh := make(chan struct{})
+			go func() {
+				echoed.Wait()
+				close(waitCh)
+			}()
+
+			select {
+			case <-waitCh:
+			case <-time.After(5 * time.Second):
+				t.Fatal("data was not echoed over the mux session")
+			}
+
+			// every connection is still open, and was forwarded over its own stream
+			stats := opts.conns.list()
+			if len(stats) != tc.conns {
+				t.Errorf("got %d tracked connections, want %d", len(stats), tc.conns)
+			}
+
+			streams := make(map[uint32]bool)
+			for _, s := range stats {
+				if streams[s.StreamID] {
+					t.Errorf("stream %d used for more than one connection", s.StreamID)
+				}
+				streams[s.StreamID] = true
+
+				if s.BytesSent != int64(tc.size) || s.BytesReceived != int64(tc.size) {
+					t.Errorf("stream %d sent %d and received %d bytes, want %d", s.StreamID, s.BytesSent,
+						s.BytesReceived, tc.size)
+				}
+
+				if s.RemoteHost != opts.Target || s.RemotePort != opts.RemotePort {
+					t.Errorf("stream %d forwarded to %s:%d, want %s:%d", s.StreamID, s.RemoteHost, s.RemotePort,
+						opts.Target, opts.RemotePort)
+				}
+			}
+
+			close(release)
+			for i := 0; i < tc.conns; i++ {
+				if err = <-results; err != nil {
+					t.Error(err)
+				}
+			}
+
+			// the session closes the listener when the context is cancelled, releasing the accept loop
+			cancel()
+			_ = lsnr.Close()
+
+			select {
+			case err = <-doneCh:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("got error %v, want %v", err, context.Canceled)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("port forwarding did not return after the context was cancelled")
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package ssmclient
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// SessionOptions configures the data channel used by the native port forwarding, SSH, and shell sessions, and is
+// set using the Options field of PortForwardingInput or ShellSessionInput.  The zero value of each field keeps the
+// default behavior, and a nil SessionOptions is the same as the zero value.  New data channel settings are added
+// here, instead of to each session input type.  See the datachannel.SsmDataChannel field of the same name for the
+// details of each option.
+type SessionOptions struct {
+	// KeepAliveTimeout closes the session if no reply to the keepalive pings is received for this long.
+	KeepAliveTimeout time.Duration
+	// WriteTimeout limits how long a write to the websocket connection may block.
+	WriteTimeout time.Duration
+	// MaxReconnects is the number of times to reconnect to the session if the websocket connection is dropped.
+	MaxReconnects int
+	// ReconnectBackoff is the delay before the 1st reconnect attempt.
+	ReconnectBackoff time.Duration
+	// MaxPayloadSize is the largest payload sent in a single message.
+	MaxPayloadSize int
+	// TerminateTimeout is how long to wait for the agent to acknowledge the end of the session.  Port forwarding
+	// sessions wait for 2 seconds if not set, and only wait once the local port is listening.
+	TerminateTimeout time.Duration
+	// UnknownMessagePolicy determines how messages not recognized by this library are handled.
+	UnknownMessagePolicy datachannel.UnknownMessagePolicy
+	// BookmarkPath saves the session details to a file, so the session can be resumed after a restart.
+	BookmarkPath string
+	// OnAgentError is called with the payload of error messages sent by the agent.
+	OnAgentError func([]byte)
+	// PreHandshake is called once the data channel is open, before the session handshake, to send any messages the
+	// session document requires first (see the README for the order messages are sent for each session type).
+	PreHandshake func(*datachannel.SsmDataChannel) error
+	// MetricsSink is sent the metrics (duration, bytes sent and received, reconnects) of the session when it ends.
+	MetricsSink MetricsSink
+	// WriteCoalesceDelay combines small writes in to fewer, larger messages (see
+	// datachannel.DefaultWriteCoalesceDelay).
+	WriteCoalesceDelay time.Duration
+	// KeepAliveInterval is the interval between the websocket pings which keep idle sessions open.  If not provided,
+	// datachannel.DefaultKeepAliveInterval is used, and a negative value disables the pings.
+	KeepAliveInterval time.Duration
+	// WebsocketDialer is used to connect to the session data channel, to use an outbound proxy or custom TLS
+	// settings.
+	WebsocketDialer *websocket.Dialer
+	// OnSessionStart is called with the ID of the session as soon as it is started, to correlate the session with
+	// the session history and CloudTrail.
+	OnSessionStart func(sessionID string)
+	// OnChannelClosed is called with the details sent by the agent when it closes the session.
+	OnChannelClosed func(*datachannel.ChannelClosedPayload)
+}
+
+// newDataChannel returns a new data channel configured using the SessionOptions, which may be nil.  The target and
+// mode describe the session in the metrics sent to the MetricsSink.
+func (o *SessionOptions) newDataChannel(target string, mode SessionMode) *datachannel.SsmDataChannel {
+	c := new(datachannel.SsmDataChannel)
+	if o == nil {
+		return c
+	}
+
+	c.KeepAliveTimeout = o.KeepAliveTimeout
+	c.WriteTimeout = o.WriteTimeout
+	c.MaxReconnects = o.MaxReconnects
+	c.ReconnectBackoff = o.ReconnectBackoff
+	c.MaxPayloadSize = o.MaxPayloadSize
+	c.TerminateTimeout = o.TerminateTimeout
+	c.UnknownMessagePolicy = o.UnknownMessagePolicy
+	c.BookmarkPath = o.BookmarkPath
+	c.OnAgentError = o.OnAgentError
+	c.PreHandshake = o.PreHandshake
+	c.WriteCoalesceDelay = o.WriteCoalesceDelay
+	c.KeepAliveInterval = o.KeepAliveInterval
+	c.Dialer = o.WebsocketDialer
+	c.OnSessionStart = o.OnSessionStart
+	c.OnChannelClosed = o.OnChannelClosed
+
+	if o.MetricsSink != nil {
+		recordMetrics(c, o.MetricsSink, target, mode)
+	}
+	return c
+}
@@ -0,0 +1,137 @@
+package ssmclient
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SessionMode is the type of session started by this library.
+type SessionMode int
+
+const (
+	// PortForwardMode forwards a local port to a port on the target instance (PortForwardingSession).
+	PortForwardMode SessionMode = iota
+	// RemoteHostPortForwardMode forwards a local port to a port on a remote host, reached through the target
+	// instance (PortForwardingSession with the Host field set, or DatabaseTunnel).
+	RemoteHostPortForwardMode
+	// SSHMode forwards an SSH connection to the target instance (SSHSession and DialSSH).
+	SSHMode
+	// ShellMode starts an interactive shell on the target instance (ShellSession).
+	ShellMode
+	// NonInteractiveCommandMode runs a single command on the target instance, without a terminal
+	// (NonInteractiveCommandSession).
+	NonInteractiveCommandMode
+)
+
+func (m SessionMode) String() string {
+	switch m {
+	case PortForwardMode:
+		return "PortForward"
+	case RemoteHostPortForwardMode:
+		return "RemoteHostPortForward"
+	case SSHMode:
+		return "SSH"
+	case ShellMode:
+		return "Shell"
+	case NonInteractiveCommandMode:
+		return "NonInteractiveCommand"
+	default:
+		return fmt.Sprintf("SessionMode(%d)", int(m))
+	}
+}
+
+// Session document names used by this library.
+const (
+	portForwardingDocument           = "AWS-StartPortForwardingSession"
+	remoteHostPortForwardingDocument = "AWS-StartPortForwardingSessionToRemoteHost"
+	sshDocument                      = "AWS-StartSSHSession"
+	shellDocument                    = "SSM-SessionManagerRunShell"
+	nonInteractiveCommandDocument    = "AWS-StartNonInteractiveCommand"
+)
+
+// ParameterSpec describes a single session document parameter.  Default is the value used by the document if the
+// parameter is not provided, and is only meaningful if Required is false.
+type ParameterSpec struct {
+	Name        string
+	Description string
+	Required    bool
+	Default     string
+}
+
+// DocumentSpec describes the session document used for a SessionMode, and the parameters it accepts.  For ShellMode,
+// the DocumentName is the default document used when ShellSessionInput.DocumentName is not set, a custom document
+// may accept other parameters.
+type DocumentSpec struct {
+	DocumentName string
+	Parameters   []ParameterSpec
+}
+
+// SessionSpec returns the session document name and parameters used for the given SessionMode.  An empty
+// DocumentSpec is returned for an unknown mode.
+func SessionSpec(mode SessionMode) DocumentSpec {
+	localPort := ParameterSpec{Name: "localPortNumber", Description: "Port number on the local host", Default: "0"}
+
+	switch mode {
+	case PortForwardMode:
+		return DocumentSpec{
+			DocumentName: portForwardingDocument,
+			Parameters: []ParameterSpec{
+				{Name: "portNumber", Description: "Port number on the target instance", Default: "80"},
+				localPort,
+			},
+		}
+	case RemoteHostPortForwardMode:
+		return DocumentSpec{
+			DocumentName: remoteHostPortForwardingDocument,
+			Parameters: []ParameterSpec{
+				{Name: "host", Description: "Host name or IP address of the remote host", Required: true},
+				{Name: "portNumber", Description: "Port number on the remote host", Default: "80"},
+				localPort,
+			},
+		}
+	case SSHMode:
+		return DocumentSpec{
+			DocumentName: sshDocument,
+			Parameters: []ParameterSpec{
+				{Name: "portNumber", Description: "SSH port number on the target instance", Default: "22"},
+			},
+		}
+	case ShellMode:
+		return DocumentSpec{DocumentName: shellDocument}
+	case NonInteractiveCommandMode:
+		return DocumentSpec{
+			DocumentName: nonInteractiveCommandDocument,
+			Parameters: []ParameterSpec{
+				{Name: "command", Description: "The command to run on the target instance", Required: true},
+			},
+		}
+	default:
+		return DocumentSpec{}
+	}
+}
+
+// Validate checks that all required parameters are set in params, and that params does not contain any parameters
+// which are not accepted by the document.
+func (s DocumentSpec) Validate(params map[string][]string) error {
+	known := make(map[string]bool, len(s.Parameters))
+	for _, p := range s.Parameters {
+		known[p.Name] = true
+
+		if p.Required && len(params[p.Name]) < 1 {
+			return fmt.Errorf("missing required parameter %s for document %s", p.Name, s.DocumentName)
+		}
+	}
+
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		if !known[k] {
+			return fmt.Errorf("unknown parameter %s for document %s", k, s.DocumentName)
+		}
+	}
+	return nil
+}
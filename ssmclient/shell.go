@@ -3,34 +3,183 @@ package ssmclient
 import (
 	"errors"
 	"io"
-	"log"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/mmmorris1975/ssm-session-client/datachannel"
 )
 
+// Terminal control characters which can be sent to the remote shell using SendSignal.  The remote terminal
+// translates these in to the equivalent signal (or end of input) for the foreground process.
+const (
+	SignalInterrupt byte = 0x03 // Ctrl-C, SIGINT
+	SignalEOF       byte = 0x04 // Ctrl-D, end of input
+	SignalSuspend   byte = 0x1a // Ctrl-Z, SIGTSTP
+	SignalQuit      byte = 0x1c // Ctrl-\, SIGQUIT
+)
+
+// shellFlushTimeout is how long to wait for the input sent to the remote shell to be acknowledged by the agent,
+// before closing the data channel when a shell session ends.
+const shellFlushTimeout = 2 * time.Second
+
+// ResizeSleepInterval is how often the local terminal size is checked, unless the ResizeStrategy is
+// ResizeSignalOnly.
+const ResizeSleepInterval = time.Millisecond * 500
+
+// ResizeStrategy determines how changes to the size of the local terminal are detected during a shell session.
+type ResizeStrategy int
+
+const (
+	// ResizeBoth checks the terminal size when the SIGWINCH signal is received, and also polls the terminal size
+	// every ResizeSleepInterval for terminals which do not send the signal.  This is the default.
+	ResizeBoth ResizeStrategy = iota
+	// ResizePoll only polls the terminal size every ResizeSleepInterval.
+	ResizePoll
+	// ResizeSignalOnly only checks the terminal size when the SIGWINCH signal is received, which avoids sending
+	// periodic terminal size messages to the agent.
+	ResizeSignalOnly
+)
+
+// TerminalSize is the number of rows and columns of a terminal.
+type TerminalSize struct {
+	Rows uint32
+	Cols uint32
+}
+
+// DefaultFallbackSize is the terminal size sent to the remote shell if the size of the local terminal can't be found.
+var DefaultFallbackSize = TerminalSize{Rows: 45, Cols: 132}
+
+// ShellSessionInput configures the shell session parameters.
+type ShellSessionInput struct {
+	// Target is the EC2 instance ID (or instance ARN) to establish the session with.
+	Target string
+	// Reason is an optional justification for the session, which is recorded in the session history and CloudTrail.
+	Reason string
+	// ResizeStrategy determines how terminal size changes are detected, the default is ResizeBoth.  Platforms
+	// without the SIGWINCH signal (Windows) ignore this setting.
+	ResizeStrategy ResizeStrategy
+	// PluginLogWriter is an optional destination for the log messages of the session manager plugin, only used by
+	// ShellPluginSessionWithInput.  If not provided, the plugin writes its own log files.
+	PluginLogWriter io.Writer
+	// FallbackSize is the terminal size sent to the remote shell if the local terminal size can't be found, or is
+	// reported as 0 rows or columns.  If not provided, DefaultFallbackSize is used.
+	FallbackSize TerminalSize
+	// MinSize is an optional floor for the terminal size sent to the remote shell, so full-screen applications are
+	// never given an unusably small terminal.
+	MinSize TerminalSize
+	// DocumentName is an optional session document to use instead of the default shell session document.
+	DocumentName string
+	// Parameters are optional parameters for the session document (see ParametersFromFile).  The default shell
+	// document accepts no parameters, AWS-StartInteractiveCommand requires a "command" parameter.
+	Parameters map[string][]string
+	// ModifyStartSessionInput is an optional function called with the StartSession API input just before the
+	// session is started, to set fields not exposed by this library.  The caller is responsible for the input
+	// remaining valid for the type of session.
+	ModifyStartSessionInput func(*ssm.StartSessionInput)
+	// Options configures the data channel used by the native session functions (see SessionOptions).
+	Options *SessionOptions
+	// TranscriptWriter is an optional destination for a timestamped record of the session output.  Each chunk is
+	// written as a line with the time, the stream name (TranscriptOutput or TranscriptInput), and the data as a Go
+	// quoted string.  An error writing the transcript stops the recording without ending the session.
+	TranscriptWriter io.Writer
+	// TranscriptInput also records the data sent to the session (keystrokes and initial commands) in the transcript.
+	// Note that this may include passwords typed at a prompt which doesn't echo them.
+	TranscriptInput bool
+	// Environment is an optional set of environment variables for the remote shell, like TERM and LANG for
+	// full-screen applications (see TerminalEnvironment).  These are passed in the EnvironmentParameter of the
+	// session document, which must be a custom DocumentName that accepts it.
+	Environment map[string]string
+	// WorkingDirectory is an optional directory to start the remote shell in, passed in the
+	// WorkingDirectoryParameter of the session document, the same as the Environment.
+	WorkingDirectory string
+}
+
 // ShellSession starts a shell session with the instance specified in the target parameter.  The aws.Config
 // parameter will be used to call the AWS SSM StartSession API, which is used as part of establishing the
 // websocket communication channel.  A vararg slice of io.Readers can be provided to send data to the
 // instance before handing control of the terminal to the user.
 func ShellSession(cfg aws.Config, target string, initCmd ...io.Reader) error {
-	c := new(datachannel.SsmDataChannel)
-	if err := c.Open(cfg, &ssm.StartSessionInput{Target: aws.String(target)}); err != nil {
+	return ShellSessionWithInput(cfg, &ShellSessionInput{Target: target}, initCmd...)
+}
+
+// ShellSessionWithInput starts a shell session the same as ShellSession, using the ShellSessionInput parameters
+// to configure the session.
+func ShellSessionWithInput(cfg aws.Config, opts *ShellSessionInput, initCmd ...io.Reader) error {
+	c, err := openShellDataChannel(cfg, opts)
+	if err != nil {
 		return err
 	}
-	defer c.Close()
+	defer func() {
+		flushInput(c)
+		_ = c.Close()
+	}()
 
 	// do platform-specific setup ... signal handling, stdin modification, etc...
-	if err := initialize(c); err != nil {
+	if err = initialize(c, opts); err != nil {
 		return err
 	}
 	defer cleanup() //nolint:errcheck // platform-specific cleanup, not called if terminated by a signal
 
+	// stdin is closed when the session ends, so the goroutine copying it to the data channel doesn't outlive us
+	stdin, err := newStdinReader()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+
+	return copyShell(c, opts, stdin, os.Stdout, initCmd...)
+}
+
+// ShellSessionRW starts a shell session with the instance specified in the target parameter, the same as
+// ShellSession, except the session input is read from in, and the output is written to out, instead of using the
+// local terminal.  This allows driving a shell session from a GUI, a network connection, or tests.
+func ShellSessionRW(cfg aws.Config, target string, in io.Reader, out io.Writer) error {
+	return ShellSessionRWWithInput(cfg, &ShellSessionInput{Target: target}, in, out)
+}
+
+// ShellSessionRWWithInput starts a shell session the same as ShellSessionRW, using the ShellSessionInput parameters
+// to configure the session.  The local terminal is not modified, and no signal handlers are installed.  Since in
+// and out may not be a terminal, the terminal size sent to the remote shell is the FallbackSize (increased to the
+// MinSize) of the ShellSessionInput, and the ResizeStrategy is not used.  The session ends when the remote shell
+// exits, reading from in may continue until the next input (or EOF) after that.
+func ShellSessionRWWithInput(cfg aws.Config, opts *ShellSessionInput, in io.Reader, out io.Writer,
+	initCmd ...io.Reader) error {
+	c, err := openShellDataChannel(cfg, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushInput(c)
+		_ = c.Close()
+	}()
+
+	rows, cols := termSize(opts, 0, 0)
+	if err = c.SetTerminalSize(rows, cols); err != nil {
+		return err
+	}
+	return copyShell(c, opts, in, out, initCmd...)
+}
+
+// openShellDataChannel starts the shell session using the ShellSessionInput parameters.
+func openShellDataChannel(cfg aws.Config, opts *ShellSessionInput) (*datachannel.SsmDataChannel, error) {
+	c := opts.Options.newDataChannel(opts.Target, ShellMode)
+	if err := c.Open(cfg, shellStartSessionInput(opts)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// copyShell sends the initCmd data, then copies in to the data channel, and the session output to out, until the
+// session ends.  The session is recorded if the ShellSessionInput has a TranscriptWriter.
+func copyShell(c datachannel.DataChannel, opts *ShellSessionInput, in io.Reader, out io.Writer,
+	initCmd ...io.Reader) error {
+	in, out, initCmd = withTranscript(opts, in, out, initCmd)
+
 	errCh := make(chan error, 5)
 	go func() {
-		if _, err := io.Copy(c, os.Stdin); err != nil {
+		if _, err := io.Copy(c, in); err != nil {
 			errCh <- err
 		}
 	}()
@@ -39,30 +188,95 @@ func ShellSession(cfg aws.Config, target string, initCmd ...io.Reader) error {
 		_, _ = io.Copy(c, cmd)
 	}
 
-	if _, err := io.Copy(os.Stdout, c); err != nil {
+	if _, err := io.Copy(out, c); err != nil {
 		if !errors.Is(err, io.EOF) {
 			errCh <- err
 		}
 	}
-	close(errCh)
 
-	return <-errCh
+	// the input goroutine may still be running, so errCh is not closed to avoid a send on a closed channel
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
+}
+
+// SendSignal sends a terminal control character (like SignalInterrupt) to the remote shell using the data channel.
+// This allows programs to deterministically interrupt or suspend a remote command, independent of how (or if) the
+// local terminal forwards the control characters typed by a user.
+func SendSignal(c datachannel.DataChannel, sig byte) error {
+	_, err := c.Write([]byte{sig})
+	return err
+}
+
+// flushInput waits for the input sent to the remote shell to be acknowledged by the agent (if the data channel
+// supports it), so commands sent just before the session ends aren't lost.  This returns immediately if the
+// agent has already closed the session.
+func flushInput(c datachannel.DataChannel) {
+	if f, ok := c.(interface{ WaitForAcks(time.Duration) error }); ok {
+		if err := f.WaitForAcks(shellFlushTimeout); err != nil {
+			logger().Debugf("input may not have been delivered: %v", err)
+		}
+	}
 }
 
-func updateTermSize(c datachannel.DataChannel) error {
+func updateTermSize(c datachannel.DataChannel, opts *ShellSessionInput) error {
 	rows, cols, err := getWinSize()
+	if err == nil && (rows < 1 || cols < 1) {
+		err = errors.New("terminal reported zero size")
+	}
+
 	if err != nil {
 		// make sure we set some default terminal size with contrived values
-		cols = 132
-		rows = 45
-		log.Printf("Could not get size of the terminal: %s, using width %d height %d\n", err, cols, rows)
+		rows, cols = termSize(opts, 0, 0)
+		logger().Warnf("Could not get size of the terminal: %s, using width %d height %d", err, cols, rows)
 	}
 
+	rows, cols = termSize(opts, rows, cols)
 	return c.SetTerminalSize(rows, cols)
 }
 
+// termSize returns the terminal size to send to the remote shell, replacing a 0 size with the FallbackSize (or
+// DefaultFallbackSize), and increasing it to at least the MinSize.
+func termSize(opts *ShellSessionInput, rows, cols uint32) (uint32, uint32) {
+	if rows < 1 || cols < 1 {
+		fallback := opts.FallbackSize
+		if fallback.Rows < 1 || fallback.Cols < 1 {
+			fallback = DefaultFallbackSize
+		}
+		rows, cols = fallback.Rows, fallback.Cols
+	}
+
+	if rows < opts.MinSize.Rows {
+		rows = opts.MinSize.Rows
+	}
+
+	if cols < opts.MinSize.Cols {
+		cols = opts.MinSize.Cols
+	}
+	return rows, cols
+}
+
 // ShellPluginSession delegates the execution of the SSM shell session to the AWS-managed session manager plugin code,
 // bypassing this libraries internal websocket code and session management.
 func ShellPluginSession(cfg aws.Config, target string) error {
-	return PluginSession(cfg, &ssm.StartSessionInput{Target: aws.String(target)})
+	return ShellPluginSessionWithInput(cfg, &ShellSessionInput{Target: target})
+}
+
+// ShellPluginSessionWithInput delegates the execution of the SSM shell session to the AWS-managed session manager
+// plugin code, using the ShellSessionInput parameters to configure the session.
+func ShellPluginSessionWithInput(cfg aws.Config, opts *ShellSessionInput) error {
+	return PluginSessionWithLogWriter(cfg, shellStartSessionInput(opts), opts.PluginLogWriter)
+}
+
+func shellStartSessionInput(opts *ShellSessionInput) *ssm.StartSessionInput {
+	in := &ssm.StartSessionInput{
+		Target:       sessionTarget(opts.Target),
+		Reason:       stringOrNil(opts.Reason),
+		DocumentName: stringOrNil(opts.DocumentName),
+		Parameters:   shellParameters(opts),
+	}
+	return modifyInput(in, opts.ModifyStartSessionInput)
 }
@@ -0,0 +1,86 @@
+package ssmclient
+
+import (
+	"os"
+	"regexp"
+	"sort"
+)
+
+// Session document parameters used for the Environment and WorkingDirectory of a ShellSessionInput.  None of the
+// AWS-provided shell documents accept these, the DocumentName must be a custom Standard_Stream or
+// InteractiveCommands document which declares them, and uses them in its shellProfile or commands.
+const (
+	// EnvironmentParameter is a StringList parameter of NAME=value entries, sorted by name.
+	EnvironmentParameter = "environment"
+	// WorkingDirectoryParameter is a String parameter with the directory to start the shell in.
+	WorkingDirectoryParameter = "workingDirectory"
+)
+
+// envNamePattern matches the environment variable names which can be passed in the EnvironmentParameter.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// terminalEnvVars are the local environment variables returned by TerminalEnvironment.
+var terminalEnvVars = []string{"TERM", "COLORTERM", "LANG", "LC_ALL", "LC_CTYPE"}
+
+// TerminalEnvironment returns the variables from the local environment which describe the terminal and locale
+// (TERM, COLORTERM, LANG, LC_ALL, LC_CTYPE), for use as the Environment of a ShellSessionInput.  Variables which
+// are not set locally are not included.
+func TerminalEnvironment() map[string]string {
+	env := make(map[string]string)
+	for _, k := range terminalEnvVars {
+		if v, ok := os.LookupEnv(k); ok && len(v) > 0 {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// shellParameters returns the session document parameters for the ShellSessionInput, which are the Parameters
+// with the Environment and WorkingDirectory added as the EnvironmentParameter and WorkingDirectoryParameter.  A
+// parameter explicitly set in the Parameters is not replaced.
+func shellParameters(opts *ShellSessionInput) map[string][]string {
+	if len(opts.Environment) < 1 && len(opts.WorkingDirectory) < 1 {
+		return opts.Parameters
+	}
+
+	params := make(map[string][]string, len(opts.Parameters)+2)
+	for k, v := range opts.Parameters {
+		params[k] = v
+	}
+
+	if env := environmentParameter(opts.Environment); len(env) > 0 {
+		if _, ok := params[EnvironmentParameter]; ok {
+			logger().Warnf("%s parameter is set, ignoring the session Environment", EnvironmentParameter)
+		} else {
+			params[EnvironmentParameter] = env
+		}
+	}
+
+	if len(opts.WorkingDirectory) > 0 {
+		if _, ok := params[WorkingDirectoryParameter]; ok {
+			logger().Warnf("%s parameter is set, ignoring the session WorkingDirectory", WorkingDirectoryParameter)
+		} else {
+			params[WorkingDirectoryParameter] = []string{opts.WorkingDirectory}
+		}
+	}
+	return params
+}
+
+// environmentParameter returns the NAME=value entries of env, sorted by name.  Invalid variable names are skipped.
+func environmentParameter(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		if !envNamePattern.MatchString(k) {
+			logger().Warnf("ignoring invalid environment variable name %q", k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, k+"="+env[k])
+	}
+	return vars
+}
@@ -0,0 +1,52 @@
+package ssmclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *ShellSessionInput
+		want map[string][]string
+	}{
+		{
+			name: "no environment",
+			opts: &ShellSessionInput{Parameters: map[string][]string{"command": {"top"}}},
+			want: map[string][]string{"command": {"top"}},
+		},
+		{
+			name: "environment and working directory",
+			opts: &ShellSessionInput{
+				Environment:      map[string]string{"TERM": "xterm-256color", "LANG": "en_US.UTF-8", "BAD-NAME": "x"},
+				WorkingDirectory: "/var/tmp",
+			},
+			want: map[string][]string{
+				EnvironmentParameter:      {"LANG=en_US.UTF-8", "TERM=xterm-256color"},
+				WorkingDirectoryParameter: {"/var/tmp"},
+			},
+		},
+		{
+			name: "explicit parameters",
+			opts: &ShellSessionInput{
+				Parameters:       map[string][]string{WorkingDirectoryParameter: {"/home/ssm-user"}},
+				Environment:      map[string]string{"TERM": "vt100"},
+				WorkingDirectory: "/var/tmp",
+			},
+			want: map[string][]string{
+				EnvironmentParameter:      {"TERM=vt100"},
+				WorkingDirectoryParameter: {"/home/ssm-user"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := shellStartSessionInput(tc.opts)
+			if !reflect.DeepEqual(in.Parameters, tc.want) {
+				t.Errorf("got parameters %v, want %v", in.Parameters, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// +build js
+
+package ssmclient
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// initialize is a no-op, there is no terminal to configure when running as WebAssembly.  A browser terminal
+// integration (like xterm.js) should use NewCommandSession, or the datachannel package directly, and send terminal
+// size changes with SetTerminalSize.
+func initialize(_ datachannel.DataChannel, _ *ShellSessionInput) error {
+	return nil
+}
+
+func cleanup() error {
+	return nil
+}
+
+// getWinSize always returns an error, so the FallbackSize of the ShellSessionInput is sent to the remote shell.
+func getWinSize() (rows, cols uint32, err error) {
+	return 0, 0, errors.New("terminal size not available")
+}
+
+func newStdinReader() (io.ReadCloser, error) {
+	return io.NopCloser(os.Stdin), nil
+}
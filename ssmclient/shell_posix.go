@@ -3,54 +3,77 @@
 package ssmclient
 
 import (
-	"log"
+	"io"
 	"os"
 	"os/signal"
 	"time"
 
 	"github.com/mmmorris1975/ssm-session-client/datachannel"
 	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
-const (
-	ResizeSleepInterval = time.Millisecond * 500
-)
-
-var origTermios *unix.Termios
+var origState *term.State
 
-func initialize(c datachannel.DataChannel) error {
+func initialize(c datachannel.DataChannel, opts *ShellSessionInput) error {
 	// configure signal handlers and immediately trigger a size update
-	installSignalHandlers(c) <- unix.SIGWINCH
+	installSignalHandlers(c, opts) <- unix.SIGWINCH
 
 	// set handle re-size timer
-	handleTerminalResize(c)
+	if opts.ResizeStrategy != ResizeSignalOnly {
+		handleTerminalResize(c, opts)
+	}
 
 	return configureStdin()
 }
 
-func installSignalHandlers(c datachannel.DataChannel) chan os.Signal {
+func installSignalHandlers(c datachannel.DataChannel, opts *ShellSessionInput) chan os.Signal {
 	sigCh := make(chan os.Signal, 10)
 
 	// for some reason we're not seeing INT, QUIT, and TERM signals :(
-	signal.Notify(sigCh, os.Interrupt, unix.SIGQUIT, unix.SIGTERM, unix.SIGWINCH)
+	signals := []os.Signal{os.Interrupt, unix.SIGQUIT, unix.SIGTERM}
+	if opts.ResizeStrategy != ResizePoll {
+		signals = append(signals, unix.SIGWINCH)
+	}
+	signal.Notify(sigCh, signals...)
 
 	go func() {
-		switch <-sigCh {
-		case unix.SIGWINCH:
-			// some terminal applications may not fire this signal when resizing (don't see it on MacOS) :(
-			// plus, does Go implement sigwinch internally for windows? (we know the OS proper doesn't)
-			_ = updateTermSize(c) // todo handle error? (datachannel.SetTerminalSize error)
-		case os.Interrupt, unix.SIGQUIT, unix.SIGTERM:
-			log.Print("exiting")
-			_ = cleanup()
-			_ = c.Close()
-			os.Exit(0)
+		for sig := range sigCh {
+			switch sig {
+			case unix.SIGWINCH:
+				// some terminal applications may not fire this signal when resizing (don't see it on MacOS) :(
+				// plus, does Go implement sigwinch internally for windows? (we know the OS proper doesn't)
+				_ = updateTermSize(c, opts) // todo handle error? (datachannel.SetTerminalSize error)
+			case os.Interrupt, unix.SIGQUIT, unix.SIGTERM:
+				logger().Infof("exiting")
+				_ = cleanup()
+				flushInput(c)
+				_ = c.Close()
+				exitFunc(0)
+			}
 		}
 	}()
 
 	return sigCh
 }
 
+func cleanup() error {
+	if origState != nil {
+		// reset Stdin to original settings
+		return term.Restore(int(os.Stdin.Fd()), origState)
+	}
+	return nil
+}
+
+// configureStdin puts the terminal in raw mode, using the correct terminal settings calls for the platform.
+// Raw mode unsets ISIG, which means that this process will no longer respond to the INT, QUIT, SUSP signals
+// (they go downstream to the instance session, which is desirable).  Which means those signals are unavailable
+// for shutting down this process.
+func configureStdin() (err error) {
+	origState, err = term.MakeRaw(int(os.Stdin.Fd()))
+	return err
+}
+
 // see also: https://godoc.org/golang.org/x/crypto/ssh/terminal#GetSize.
 func getWinSize() (rows, cols uint32, err error) {
 	var sz *unix.Winsize
@@ -65,12 +88,50 @@ func getWinSize() (rows, cols uint32, err error) {
 
 // This approach is inspired by AWS's own client:
 // https://github.com/aws/session-manager-plugin/blob/65933d1adf368d1efde7380380a19a7a691340c1/src/sessionmanagerplugin/session/shellsession/shellsession.go#L98-L104
-func handleTerminalResize(c datachannel.DataChannel) {
+func handleTerminalResize(c datachannel.DataChannel, opts *ShellSessionInput) {
 	go func() {
 		for {
-			_ = updateTermSize(c)
+			_ = updateTermSize(c, opts)
 			// repeating this loop for every 500ms
 			time.Sleep(ResizeSleepInterval)
 		}
 	}()
 }
+
+// stdinReader is a reader for stdin which can be interrupted.  Read waits for input on both stdin and a pipe (see
+// waitForInput), so calling Close wakes up a blocked Read, which then returns io.EOF.
+type stdinReader struct {
+	pr, pw *os.File
+	done   bool // only accessed by Read, which must not be called concurrently
+}
+
+func newStdinReader() (io.ReadCloser, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &stdinReader{pr: pr, pw: pw}, nil
+}
+
+func (r *stdinReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	closed, err := waitForInput(int(os.Stdin.Fd()), int(r.pr.Fd()))
+	if err != nil {
+		return 0, err
+	}
+
+	if closed {
+		r.done = true
+		_ = r.pr.Close()
+		return 0, io.EOF
+	}
+	return os.Stdin.Read(p)
+}
+
+// Close stops any pending or future Read.  Closing the write end of the pipe makes the read end ready.
+func (r *stdinReader) Close() error {
+	return r.pw.Close()
+}
@@ -0,0 +1,136 @@
+// +build !windows,!js
+
+package ssmclient
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// stdinPipe returns a pipe to use as stdin, and the file to write the input to.
+func stdinPipe() (stdin, input *os.File, err error) {
+	return os.Pipe()
+}
+
+// stdinTerminal returns a terminal in raw mode to use as stdin, and the file to write the input to.  Terminal devices
+// are tested separately from pipes, since some platforms (macOS) can not poll(2) them.
+func stdinTerminal() (stdin, input *os.File, err error) {
+	input, stdin, err = openPty()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err = term.MakeRaw(int(stdin.Fd())); err != nil {
+		_ = input.Close()
+		_ = stdin.Close()
+		return nil, nil, err
+	}
+	return stdin, input, nil
+}
+
+func TestStdinReader_Close(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string // written to stdin, and read, before the reader is closed
+	}{
+		{name: "no input"},
+		{name: "input before close", input: []string{"ls -l\n"}},
+		{name: "multiple reads", input: []string{"a", "bc", "def\n"}},
+	}
+
+	stdins := []struct {
+		name string
+		open func() (stdin, input *os.File, err error)
+	}{
+		{name: "pipe", open: stdinPipe},
+		{name: "terminal", open: stdinTerminal},
+	}
+
+	for _, in := range stdins {
+		for _, tc := range tests {
+			t.Run(in.name+"/"+tc.name, func(t *testing.T) {
+				testStdinReaderClose(t, in.open, tc.input)
+			})
+		}
+	}
+}
+
+// testStdinReaderClose reads the input from a stdinReader using stdin from open, then checks Close interrupts the
+// blocked Read.
+func testStdinReaderClose(t *testing.T, open func() (stdin, input *os.File, err error), input []string) {
+	// stdin stays open for the whole test, so a Read which isn't interrupted by Close never returns
+	in, w, err := open()
+	if err != nil {
+		t.Skipf("stdin not available: %v", err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = in
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		_ = in.Close()
+		_ = w.Close()
+	})
+
+	r, err := newStdinReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readCh := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				readCh <- string(buf[:n])
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+
+	for _, data := range input {
+		if _, err = w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case got := <-readCh:
+			if got != data {
+				t.Errorf("read %q, want %q", got, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("input %q was not read", data)
+		}
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err = <-errCh:
+		if !errors.Is(err, io.EOF) {
+			t.Errorf("got error %v, want %v", err, io.EOF)
+		}
+	case got := <-readCh:
+		t.Errorf("unexpected input %q after close", got)
+	case <-time.After(time.Second):
+		t.Fatal("read was not interrupted by Close")
+	}
+
+	// once closed, the reader stays at EOF
+	if _, err = r.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v after close, want %v", err, io.EOF)
+	}
+}
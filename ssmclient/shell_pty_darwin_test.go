@@ -0,0 +1,47 @@
+package ssmclient
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the controller and terminal device of a new pseudo-terminal.
+func openPty() (ptm, tty *os.File, err error) {
+	ptm, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fd := int(ptm.Fd())
+	name := make([]byte, 128)
+
+	err = unix.IoctlSetInt(fd, unix.TIOCPTYGRANT, 0)
+	if err == nil {
+		err = unix.IoctlSetInt(fd, unix.TIOCPTYUNLK, 0)
+	}
+
+	if err == nil {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCPTYGNAME),
+			uintptr(unsafe.Pointer(&name[0])))
+		if errno != 0 {
+			err = errno
+		}
+	}
+
+	if err == nil {
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		tty, err = os.OpenFile(string(name), os.O_RDWR|unix.O_NOCTTY, 0)
+	}
+
+	if err != nil {
+		_ = ptm.Close()
+		return nil, nil, err
+	}
+	return ptm, tty, nil
+}
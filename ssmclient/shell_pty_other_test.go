@@ -0,0 +1,13 @@
+// +build !windows,!js,!linux,!darwin
+
+package ssmclient
+
+import (
+	"errors"
+	"os"
+)
+
+// openPty returns an error, opening a pseudo-terminal is only implemented for Linux and macOS.
+func openPty() (ptm, tty *os.File, err error) {
+	return nil, nil, errors.New("pseudo-terminal not supported on this platform")
+}
@@ -0,0 +1,155 @@
+package ssmclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/uuid"
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// commandTermCols is the terminal width reported for command sessions.  It is wide enough that command output is
+// not wrapped by the remote terminal.
+const commandTermCols = 1024
+
+// CommandResult is the outcome of a single command executed by CommandSession.Run().  Output contains everything
+// written to the terminal by the command (stdout and stderr are combined by the remote terminal), with line endings
+// converted to \n.
+type CommandResult struct {
+	Command  string
+	Output   []byte
+	ExitCode int
+}
+
+// CommandSession is a non-interactive shell session used to run a sequence of commands, without the overhead of
+// starting a new session for each one.  Commands are run by a POSIX shell (sh/bash) on the instance, so this
+// does not support Windows instances.
+type CommandSession struct {
+	c       *datachannel.SsmDataChannel
+	marker  string
+	re      *regexp.Regexp
+	pending []byte
+}
+
+// NewCommandSession starts a shell session with the instance specified in the ShellSessionInput, and configures the
+// remote shell for running commands with Run().  The local terminal is not used.  The session must be ended by
+// calling Close().
+func NewCommandSession(cfg aws.Config, opts *ShellSessionInput) (*CommandSession, error) {
+	c := opts.Options.newDataChannel(opts.Target, ShellMode)
+	if err := c.Open(cfg, shellStartSessionInput(opts)); err != nil {
+		return nil, err
+	}
+
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	s := &CommandSession{
+		c:      c,
+		marker: id,
+		re:     regexp.MustCompile(`__ssc_` + id + ` (\d+)\r?\n`),
+	}
+
+	if err := c.SetTerminalSize(24, commandTermCols); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+
+	// turn off input echo and prompts, so the output of each command only contains what the command wrote
+	if _, err := s.run("stty -echo; PS1=''; PS2=''; export PS1 PS2"); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// RunCommand runs a single command on the target instance, and returns everything written to the terminal of the
+// remote shell until the session ends (stdout and stderr are combined by the terminal), with line endings converted
+// to \n.  The command is sent to the shell followed by exit, so this works with any shell which accepts exit
+// (including PowerShell), but the output also contains the shell prompt and the echoed input.  The exit code of the
+// command is not available, use NewCommandSession if it is needed.  The local terminal is not used.
+func RunCommand(cfg aws.Config, target string, cmd string) ([]byte, error) {
+	opts := &ShellSessionInput{Target: target}
+
+	c := opts.Options.newDataChannel(opts.Target, ShellMode)
+	if err := c.Open(cfg, shellStartSessionInput(opts)); err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.SetTerminalSize(24, commandTermCols); err != nil {
+		return nil, err
+	}
+	return runCommand(c, cmd)
+}
+
+// runCommand sends the command and exit to the remote shell, each terminated by a carriage return (the Enter key),
+// and returns the output until the agent closes the session after the shell exits.
+func runCommand(c datachannel.DataChannel, cmd string) ([]byte, error) {
+	if _, err := c.Write([]byte(strings.TrimSpace(cmd) + "\rexit\r")); err != nil {
+		return nil, err
+	}
+
+	out := new(bytes.Buffer)
+	if _, err := io.Copy(out, c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return bytes.ReplaceAll(out.Bytes(), []byte("\r\n"), []byte("\n")), nil
+}
+
+// Run executes the commands sequentially, waiting for each command to complete before sending the next, and
+// returns the result of each command.  Each command must be a complete shell command line.  A non-zero exit
+// code does not stop later commands from running; an error is only returned if the session fails (including if
+// a command exits the remote shell), along with the results of the commands which completed.
+func (s *CommandSession) Run(commands ...string) ([]CommandResult, error) {
+	results := make([]CommandResult, 0, len(commands))
+
+	for _, cmd := range commands {
+		r, err := s.run(cmd)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *r)
+	}
+	return results, nil
+}
+
+// Close ends the shell session.
+func (s *CommandSession) Close() error {
+	_ = s.c.TerminateSession()
+	return s.c.Close()
+}
+
+// run sends the command, followed by a command which prints the completion marker and exit code.  The marker is
+// split in the printf arguments, so it only appears in the output when printf runs, not if the command is echoed.
+func (s *CommandSession) run(cmd string) (*CommandResult, error) {
+	input := fmt.Sprintf("%s\nprintf '%%s%%s %%d\\n' '__ssc_' '%s' \"$?\"\n", strings.TrimSpace(cmd), s.marker)
+	if _, err := s.c.Write([]byte(input)); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		if m := s.re.FindSubmatchIndex(s.pending); m != nil {
+			code, _ := strconv.Atoi(string(s.pending[m[2]:m[3]]))
+			out := bytes.ReplaceAll(s.pending[:m[0]], []byte("\r\n"), []byte("\n"))
+
+			s.pending = append([]byte(nil), s.pending[m[1]:]...)
+			return &CommandResult{Command: cmd, Output: out, ExitCode: code}, nil
+		}
+
+		n, err := s.c.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		payload, err := s.c.HandleMsg(buf[:n])
+		s.pending = append(s.pending, payload...)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
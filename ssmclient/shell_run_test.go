@@ -0,0 +1,94 @@
+package ssmclient
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// shellAgentChannel is a datachannel.DataChannel which acts as the agent of a shell session.  Reading blocks until the
+// input ends with exit, then returns the output and io.EOF.  Calling any other DataChannel method panics.
+type shellAgentChannel struct {
+	datachannel.DataChannel
+	mu     sync.Mutex
+	input  bytes.Buffer
+	output bytes.Buffer
+	ready  *sync.Cond
+	closed bool
+}
+
+func newShellAgentChannel(output string) *shellAgentChannel {
+	a := new(shellAgentChannel)
+	a.output.WriteString(output)
+	a.ready = sync.NewCond(&a.mu)
+	return a
+}
+
+func (a *shellAgentChannel) Write(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.input.Write(b)
+	if bytes.HasSuffix(a.input.Bytes(), []byte("exit\r")) {
+		a.closed = true
+		a.ready.Broadcast()
+	}
+	return len(b), nil
+}
+
+func (a *shellAgentChannel) Read(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for !a.closed {
+		a.ready.Wait()
+	}
+
+	if a.output.Len() < 1 {
+		return 0, io.EOF
+	}
+	return a.output.Read(b)
+}
+
+func (a *shellAgentChannel) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, struct{ io.Reader }{a})
+}
+
+func TestRunCommand(t *testing.T) {
+	const cmd = "uname -s"
+
+	c := newShellAgentChannel("$ uname -s\r\nLinux\r\n$ exit\r\n")
+
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := runCommand(c, "  "+cmd+"\n")
+		done <- result{out, err}
+	}()
+
+	var r result
+	select {
+	case r = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not return after the session ended")
+	}
+
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+
+	if want := "$ uname -s\nLinux\n$ exit\n"; string(r.out) != want {
+		t.Errorf("got output %q, want %q", r.out, want)
+	}
+
+	if want := cmd + "\rexit\r"; c.input.String() != want {
+		t.Errorf("sent %q to the shell, want %q", c.input.String(), want)
+	}
+}
@@ -0,0 +1,37 @@
+package ssmclient
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput waits until stdin or the closer file descriptor is ready to read, using select(2), since poll(2) on
+// macOS does not support terminal devices (it reports POLLNVAL).  If the closer is ready, closed is true.
+func waitForInput(stdin, closer int) (closed bool, err error) {
+	nfd := stdin
+	if closer > nfd {
+		nfd = closer
+	}
+
+	for {
+		fds := new(unix.FdSet)
+		fds.Set(stdin)
+		fds.Set(closer)
+
+		if _, err = unix.Select(nfd+1, fds, nil, nil, nil); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return false, err
+		}
+
+		if fds.IsSet(closer) {
+			return true, nil
+		}
+
+		if fds.IsSet(stdin) {
+			return false, nil
+		}
+	}
+}
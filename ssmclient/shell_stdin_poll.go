@@ -0,0 +1,35 @@
+// +build !windows,!js,!darwin
+
+package ssmclient
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput waits until stdin or the closer file descriptor is ready to read, using poll(2).  If the closer is
+// ready, closed is true.
+func waitForInput(stdin, closer int) (closed bool, err error) {
+	fds := []unix.PollFd{
+		{Fd: int32(stdin), Events: unix.POLLIN},
+		{Fd: int32(closer), Events: unix.POLLIN},
+	}
+
+	for {
+		if _, err = unix.Poll(fds, -1); err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			return false, err
+		}
+
+		if fds[1].Revents != 0 {
+			return true, nil
+		}
+
+		if fds[0].Revents != 0 {
+			return false, nil
+		}
+	}
+}
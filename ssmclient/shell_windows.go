@@ -3,23 +3,145 @@
 package ssmclient
 
 import (
-	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/mmmorris1975/ssm-session-client/datachannel"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	// console modes saved by configureConsole, and restored by cleanup.
+	origInMode, origOutMode uint32
+	consoleConfigured       bool
+
+	// resizeDone stops the goroutine started by handleTerminalResize, it is closed by cleanup.
+	resizeMu   sync.Mutex
+	resizeDone chan struct{}
 )
 
-func initialize(c datachannel.DataChannel) error {
-	// todo
-	//  - interrogate terminal size and call updateTermSize()
-	//  - setup stdin so that it behaves as expected
-	//  - signal handling?
+// initialize puts the console in raw mode with VT processing enabled, and starts polling the console size, since
+// Windows has no equivalent of the SIGWINCH signal.
+func initialize(c datachannel.DataChannel, opts *ShellSessionInput) error {
+	installSignalHandlers(c)
+
+	if err := configureConsole(); err != nil {
+		return err
+	}
+
+	_ = updateTermSize(c, opts)
+	handleTerminalResize(c, opts)
 	return nil
 }
 
+// installSignalHandlers ends the session when the console is closed, or an interrupt is received outside of raw
+// mode.  In raw mode, Ctrl-C is read from stdin and sent to the remote shell.
+func installSignalHandlers(c datachannel.DataChannel) {
+	sigCh := make(chan os.Signal, 10)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		for range sigCh {
+			logger().Infof("exiting")
+			_ = cleanup()
+			flushInput(c)
+			_ = c.Close()
+			exitFunc(0)
+		}
+	}()
+}
+
+// configureConsole puts the console in raw mode.  Disabling ENABLE_PROCESSED_INPUT means Ctrl-C is read as input
+// instead of generating an interrupt, and ENABLE_VIRTUAL_TERMINAL_INPUT translates keys (like the arrow keys) to the
+// VT sequences expected by the remote shell.  ENABLE_VIRTUAL_TERMINAL_PROCESSING makes the console interpret the VT
+// sequences in the output of the remote shell.
+func configureConsole() error {
+	in := windows.Handle(os.Stdin.Fd())
+	out := windows.Handle(os.Stdout.Fd())
+
+	if err := windows.GetConsoleMode(in, &origInMode); err != nil {
+		return err
+	}
+
+	if err := windows.GetConsoleMode(out, &origOutMode); err != nil {
+		return err
+	}
+
+	raw := origInMode &^ (windows.ENABLE_ECHO_INPUT | windows.ENABLE_PROCESSED_INPUT | windows.ENABLE_LINE_INPUT)
+	if err := windows.SetConsoleMode(in, raw|windows.ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		return err
+	}
+	consoleConfigured = true
+
+	vt := origOutMode | windows.ENABLE_PROCESSED_OUTPUT | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING
+	return windows.SetConsoleMode(out, vt)
+}
+
 func cleanup() error {
-	// todo - reset stdin to original settings
-	return nil
+	stopTerminalResize()
+
+	if !consoleConfigured {
+		return nil
+	}
+
+	// reset the console to the original settings
+	if err := windows.SetConsoleMode(windows.Handle(os.Stdin.Fd()), origInMode); err != nil {
+		return err
+	}
+	return windows.SetConsoleMode(windows.Handle(os.Stdout.Fd()), origOutMode)
 }
 
+// getWinSize returns the size of the visible console window, not the (usually much larger) screen buffer.
 func getWinSize() (rows, cols uint32, err error) {
-	return 0, 0, errors.New("TODO - not implemented")
+	var info windows.ConsoleScreenBufferInfo
+	if err = windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+
+	return uint32(info.Window.Bottom - info.Window.Top + 1), uint32(info.Window.Right - info.Window.Left + 1), nil
+}
+
+// handleTerminalResize polls the console size every ResizeSleepInterval, until stopTerminalResize is called.  The
+// ResizeStrategy is ignored, since there is no resize signal.
+func handleTerminalResize(c datachannel.DataChannel, opts *ShellSessionInput) {
+	done := make(chan struct{})
+
+	resizeMu.Lock()
+	resizeDone = done
+	resizeMu.Unlock()
+
+	go func() {
+		t := time.NewTicker(ResizeSleepInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				_ = updateTermSize(c, opts)
+			}
+		}
+	}()
+}
+
+// stopTerminalResize stops polling the console size, if started.  This is safe to call more than once.
+func stopTerminalResize() {
+	resizeMu.Lock()
+	defer resizeMu.Unlock()
+
+	if resizeDone != nil {
+		close(resizeDone)
+		resizeDone = nil
+	}
+}
+
+// newStdinReader returns stdin, since there is no way to interrupt a blocked read from the console.  The goroutine
+// copying stdin to the session will exit after the next input once the session ends.
+func newStdinReader() (io.ReadCloser, error) {
+	return io.NopCloser(os.Stdin), nil
 }
@@ -0,0 +1,50 @@
+// +build windows
+
+package ssmclient
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// resizeCounter is a datachannel.DataChannel which counts the calls to SetTerminalSize.  Calling any other
+// DataChannel method panics.
+type resizeCounter struct {
+	datachannel.DataChannel
+	calls int32
+}
+
+func (r *resizeCounter) SetTerminalSize(uint32, uint32) error {
+	atomic.AddInt32(&r.calls, 1)
+	return nil
+}
+
+func TestHandleTerminalResize_Cleanup(t *testing.T) {
+	c := new(resizeCounter)
+	handleTerminalResize(c, new(ShellSessionInput))
+
+	deadline := time.Now().Add(4 * ResizeSleepInterval)
+	for atomic.LoadInt32(&c.calls) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("console size was not polled")
+		}
+		time.Sleep(ResizeSleepInterval / 10)
+	}
+
+	// cleanup is called again by the signal handler if the session is interrupted
+	for i := 0; i < 2; i++ {
+		if err := cleanup(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an update already in progress when cleanup was called may still complete
+	stopped := atomic.LoadInt32(&c.calls)
+	time.Sleep(3 * ResizeSleepInterval)
+	if n := atomic.LoadInt32(&c.calls); n > stopped+1 {
+		t.Errorf("console size polled %d times after cleanup", n-stopped)
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/mmmorris1975/ssm-session-client/datachannel"
 	"io"
-	"log"
+	"net"
 	"os"
 	"strconv"
 )
@@ -17,72 +17,127 @@ import (
 // if no RemotePort is specified, the default SSH port (22) will be used. The aws.Config parameter is used to call
 // the AWS SSM StartSession API, which is used as part of establishing the websocket communication channel.
 func SSHSession(cfg aws.Config, opts *PortForwardingInput) error {
-	var port = "22"
-	if opts.RemotePort > 0 {
-		port = strconv.Itoa(opts.RemotePort)
-	}
-
-	in := &ssm.StartSessionInput{
-		DocumentName: aws.String("AWS-StartSSHSession"),
-		Target:       aws.String(opts.Target),
-		Parameters: map[string][]string{
-			"portNumber": {port},
-		},
-	}
+	return sshSession(cfg, opts, nil)
+}
 
-	c := new(datachannel.SsmDataChannel)
-	if err := c.Open(cfg, in); err != nil {
+// sshSession runs the SSH session for SSHSession, calling ready (if set) once the session handshake completes,
+// before any data from Stdin is sent.  If ready returns an error, the session is ended.
+func sshSession(cfg aws.Config, opts *PortForwardingInput, ready func() error) error {
+	c := opts.Options.newDataChannel(opts.Target, SSHMode)
+	if err := c.Open(cfg, sshStartSessionInput(opts)); err != nil {
 		return err
 	}
 	defer func() {
-		_ = c.TerminateSession()
+		if opts.DisconnectOnExit {
+			_ = c.DisconnectPort()
+		} else {
+			_ = c.TerminateSession()
+		}
 		_ = c.Close()
 	}()
 
 	installSignalHandler(c)
 
-	log.Print("waiting for handshake")
+	logger().Debugf("waiting for handshake")
 	if err := c.WaitForHandshakeComplete(); err != nil {
 		return err
 	}
-	log.Print("handshake complete")
+	logger().Debugf("handshake complete")
+
+	if ready != nil {
+		if err := ready(); err != nil {
+			return err
+		}
+	}
 
+	return copySSH(c, os.Stdin, os.Stdout)
+}
+
+// copySSH copies in to the data channel, and the session output to out, until the session ends.  When in reaches
+// EOF, the agent is told to disconnect from the SSH server, so the session ends once the remaining output is
+// received.
+func copySSH(c datachannel.DataChannel, in io.Reader, out io.Writer) error {
 	errCh := make(chan error, 5)
 	go func() {
-		if _, err := io.Copy(c, os.Stdin); err != nil {
-			log.Printf("error copying from stdin to websocket: %v", err)
+		if _, err := io.Copy(c, in); err != nil {
+			logger().Errorf("error copying from stdin to websocket: %v", err)
 			errCh <- err
 		}
-		log.Print("copy from stdin to websocket finished")
+		logger().Debugf("copy from stdin to websocket finished")
+
+		// nothing more will be sent, so have the agent close its connection to the SSH server.  The agent ends
+		// the session once the connection is closed, which ends the copy to stdout after the remaining output.
+		_ = c.DisconnectPort()
 	}()
 
-	if _, err := io.Copy(os.Stdout, c); err != nil {
+	if _, err := io.Copy(out, c); err != nil {
 		if !errors.Is(err, io.EOF) {
-			log.Printf("error copying from websocket to stdout: %v", err)
+			logger().Errorf("error copying from websocket to stdout: %v", err)
 			errCh <- err
 		}
-		log.Print("EOF received from websocket -> stdout copy")
+		logger().Debugf("EOF received from websocket -> stdout copy")
 	}
-	close(errCh)
 
-	return <-errCh
+	// the stdin goroutine may still be running, so errCh is not closed to avoid a send on a closed channel
+	select {
+	case err := <-errCh:
+		return err
+	default:
+		return nil
+	}
 }
 
 // SSHPluginSession delegates the execution of the SSM SSH integration to the AWS-managed session manager plugin code,
 // bypassing this libraries internal websocket code and connection management.
 func SSHPluginSession(cfg aws.Config, opts *PortForwardingInput) error {
-	var port = "22"
-	if opts.RemotePort > 0 {
-		port = strconv.Itoa(opts.RemotePort)
+	return PluginSessionWithLogWriter(cfg, sshStartSessionInput(opts), opts.PluginLogWriter)
+}
+
+// DialSSH starts an SSH session with the target instance, the same as SSHSession, and returns the session as a
+// net.Conn instead of connecting it to Stdin and Stdout.  The connection is the transport for the SSH protocol, so it
+// can be passed to ssh.NewClientConn (from golang.org/x/crypto/ssh) to create an SSH client, which can in turn dial
+// onward to other hosts (for example, using an instance as a bastion host).  Closing the connection terminates the
+// SSM session.  The connection's deadlines apply to the underlying websocket connection.
+func DialSSH(cfg aws.Config, opts *PortForwardingInput) (net.Conn, error) {
+	c, err := openSSHDataChannel(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newDataChannelConn(c), nil
+}
+
+// openSSHDataChannel starts the SSH session, and waits for the session handshake to complete.  The caller is
+// responsible for terminating the session and closing the returned data channel.
+func openSSHDataChannel(cfg aws.Config, opts *PortForwardingInput) (*datachannel.SsmDataChannel, error) {
+	c := opts.Options.newDataChannel(opts.Target, SSHMode)
+	if err := c.Open(cfg, sshStartSessionInput(opts)); err != nil {
+		return nil, err
+	}
+
+	if err := c.WaitForHandshakeComplete(); err != nil {
+		_ = c.TerminateSession()
+		_ = c.Close()
+		return nil, err
 	}
+	return c, nil
+}
 
+func sshStartSessionInput(opts *PortForwardingInput) *ssm.StartSessionInput {
 	in := &ssm.StartSessionInput{
-		DocumentName: aws.String("AWS-StartSSHSession"),
-		Target:       aws.String(opts.Target),
+		DocumentName: aws.String(sshDocument),
+		Target:       sessionTarget(opts.Target),
 		Parameters: map[string][]string{
-			"portNumber": {port},
+			"portNumber": {strconv.Itoa(sshPort(opts))},
 		},
+		Reason: stringOrNil(opts.Reason),
 	}
+	return modifyInput(in, opts.ModifyStartSessionInput)
+}
 
-	return PluginSession(cfg, in)
+// sshPort returns the remote port from the PortForwardingInput, or the default SSH port (22) if not set.
+func sshPort(opts *PortForwardingInput) int {
+	if opts.RemotePort > 0 {
+		return opts.RemotePort
+	}
+	return 22
 }
@@ -0,0 +1,83 @@
+package ssmclient
+
+import (
+	"net"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// dataChannelConn adapts a data channel, which has completed the port session handshake, to the net.Conn
+// interface, so it can be used as the transport for protocols layered on top of it (like SSH).
+type dataChannelConn struct {
+	c       *datachannel.SsmDataChannel
+	msgBuf  []byte
+	pending []byte
+	err     error
+}
+
+// newDataChannelConn returns the connection for the data channel, starting with any output received before the
+// handshake completed.
+func newDataChannelConn(c *datachannel.SsmDataChannel) *dataChannelConn {
+	return &dataChannelConn{c: c, msgBuf: make([]byte, 4096), pending: c.PendingOutput()}
+}
+
+// Read returns the payload of the messages received from the data channel, reading as many messages as needed
+// until there is payload data available.  Any payload received along with an error (like the output included
+// in a ChannelClosed message) is returned before the error.
+func (d *dataChannelConn) Read(p []byte) (int, error) {
+	for len(d.pending) < 1 {
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		var n int
+		n, d.err = d.c.Read(d.msgBuf)
+		if d.err != nil {
+			continue
+		}
+
+		var payload []byte
+		payload, d.err = d.c.HandleMsg(d.msgBuf[:n])
+
+		// the payload may reference msgBuf, which is overwritten on the next read
+		d.pending = append(d.pending, payload...)
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+func (d *dataChannelConn) Write(p []byte) (int, error) {
+	return d.c.Write(p)
+}
+
+// Close terminates the SSM session, and closes the data channel.
+func (d *dataChannelConn) Close() error {
+	_ = d.c.TerminateSession()
+	return d.c.Close()
+}
+
+func (d *dataChannelConn) LocalAddr() net.Addr {
+	return d.c.LocalAddr()
+}
+
+func (d *dataChannelConn) RemoteAddr() net.Addr {
+	return d.c.RemoteAddr()
+}
+
+func (d *dataChannelConn) SetDeadline(t time.Time) error {
+	if err := d.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return d.SetWriteDeadline(t)
+}
+
+func (d *dataChannelConn) SetReadDeadline(t time.Time) error {
+	return d.c.UnderlyingConn().SetReadDeadline(t)
+}
+
+func (d *dataChannelConn) SetWriteDeadline(t time.Time) error {
+	return d.c.UnderlyingConn().SetWriteDeadline(t)
+}
@@ -0,0 +1,57 @@
+package ssmclient
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHExec runs a single command on the target instance using an SSH connection over an SSM session, without
+// requesting a PTY.  The PortForwardingInput is used the same as with SSHSession, and the ssh.ClientConfig must
+// provide the user, authentication methods, and host key verification for the SSH connection.  The output written
+// to stdout and stderr by the command is returned along with the command's exit status.  A non-zero exit status is
+// not considered an error, the returned error is only set if the command could not be run (in which case the exit
+// status is -1).
+func SSHExec(cfg aws.Config, opts *PortForwardingInput, sshCfg *ssh.ClientConfig,
+	command string) (stdout, stderr []byte, exitCode int, err error) {
+	conn, err := DialSSH(cfg, opts)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	defer conn.Close()
+
+	// the address is used for host key verification, use the same value the ssh ProxyCommand would see as %h:%p
+	addr := net.JoinHostPort(opts.Target, strconv.Itoa(sshPort(opts)))
+	sc, chans, reqs, err := ssh.NewClientConn(conn, addr, sshCfg)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	client := ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+
+	sess, err := client.NewSession()
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	defer sess.Close()
+
+	outBuf := new(bytes.Buffer)
+	errBuf := new(bytes.Buffer)
+	sess.Stdout = outBuf
+	sess.Stderr = errBuf
+
+	if err = sess.Run(command); err != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			return outBuf.Bytes(), errBuf.Bytes(), exitErr.ExitStatus(), nil
+		}
+		return outBuf.Bytes(), errBuf.Bytes(), -1, err
+	}
+
+	return outBuf.Bytes(), errBuf.Bytes(), 0, nil
+}
@@ -0,0 +1,58 @@
+package ssmclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+)
+
+// instanceConnectKeyRefresh is how long after sending an SSH public key with EC2 Instance Connect the key is sent
+// again, once the session is ready.  Keys are only valid for 60 seconds, so a session which is slow to start could
+// leave the SSH client too little time to authenticate.
+const instanceConnectKeyRefresh = 30 * time.Second
+
+// EC2InstanceConnectSSHSession starts an SSH session the same as SSHSession, after using the EC2 Instance Connect
+// SendSSHPublicKey API to allow the public key to log in to the instance as osUser.  The publicKey is in the OpenSSH
+// authorized_keys format (see FindSSHPublicKey), and the SSH client must authenticate using the matching private
+// key.  The key is only valid for 60 seconds, so it is sent again if the session took more than 30 seconds to be
+// ready.  The Target of the PortForwardingInput must be an EC2 instance ID or ARN (see ResolveTarget), managed
+// instances are not supported by EC2 Instance Connect.
+func EC2InstanceConnectSSHSession(cfg aws.Config, opts *PortForwardingInput, osUser string, publicKey string) error {
+	id, ok := instanceIDFromTarget(opts.Target)
+	if !ok || strings.HasPrefix(id, "mi-") {
+		return fmt.Errorf("EC2 Instance Connect requires an EC2 instance ID target, not %s", opts.Target)
+	}
+
+	sent := time.Now()
+	if err := sendSSHPublicKey(cfg, id, osUser, publicKey); err != nil {
+		return err
+	}
+
+	return sshSession(cfg, opts, func() error {
+		if time.Since(sent) < instanceConnectKeyRefresh {
+			return nil
+		}
+		logger().Debugf("session was slow to start, sending SSH public key again")
+		return sendSSHPublicKey(cfg, id, osUser, publicKey)
+	})
+}
+
+// sendSSHPublicKey calls the EC2 Instance Connect SendSSHPublicKey API to allow the public key to log in to the
+// instance as osUser for the next 60 seconds.
+func sendSSHPublicKey(cfg aws.Config, instanceID, osUser, publicKey string) error {
+	in := &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(instanceID),
+		InstanceOSUser: aws.String(osUser),
+		SSHPublicKey:   aws.String(publicKey),
+	}
+
+	client := ec2instanceconnect.NewFromConfig(clientConfig(cfg))
+	if _, err := client.SendSSHPublicKey(context.Background(), in); err != nil {
+		return fmt.Errorf("error sending SSH public key for %s@%s with EC2 Instance Connect: %w", osUser, instanceID, err)
+	}
+	return nil
+}
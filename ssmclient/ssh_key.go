@@ -0,0 +1,94 @@
+package ssmclient
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoSSHPublicKey is the error returned if no SSH public key usable with EC2 Instance Connect can be found.
+var ErrNoSSHPublicKey = errors.New("no usable SSH public key found")
+
+var (
+	// the default identity files, in the order of precedence used by OpenSSH.
+	defaultSSHKeyFiles = []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "id_xmss", "id_dsa"}
+
+	// EC2 Instance Connect only supports RSA and ED25519 keys.
+	supportedSSHKeyTypes = map[string]bool{ssh.KeyAlgoRSA: true, ssh.KeyAlgoED25519: true}
+)
+
+// FindSSHPublicKey looks for an SSH public key to use with EC2 Instance Connect, using the same places OpenSSH would
+// look for keys.  Keys loaded in the SSH agent listening on SSH_AUTH_SOCK are checked first, then the public key file
+// of the default identity files in ~/.ssh.  The first key of a type supported by EC2 Instance Connect (RSA, ED25519)
+// is returned in the OpenSSH authorized_keys format.  If no suitable key is found, ErrNoSSHPublicKey is returned.
+func FindSSHPublicKey() (string, error) {
+	if k, err := agentPublicKey(); err == nil {
+		return k, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range defaultSSHKeyFiles {
+		if k, err := fileSSHPublicKey(filepath.Join(home, ".ssh", f+".pub")); err == nil {
+			return k, nil
+		}
+	}
+
+	return "", ErrNoSSHPublicKey
+}
+
+func agentPublicKey() (string, error) {
+	sock, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	if !ok || len(sock) < 1 {
+		return "", ErrNoSSHPublicKey
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	keys, err := agent.NewClient(conn).List()
+	if err != nil {
+		return "", err
+	}
+
+	for _, k := range keys {
+		if supportedSSHKeyTypes[k.Type()] {
+			return formatSSHPublicKey(k), nil
+		}
+	}
+
+	return "", ErrNoSSHPublicKey
+}
+
+func fileSSHPublicKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	k, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return "", err
+	}
+
+	if !supportedSSHKeyTypes[k.Type()] {
+		return "", ErrNoSSHPublicKey
+	}
+
+	return formatSSHPublicKey(k), nil
+}
+
+func formatSSHPublicKey(k ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(k)))
+}
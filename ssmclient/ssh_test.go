@@ -0,0 +1,119 @@
+package ssmclient
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mmmorris1975/ssm-session-client/datachannel"
+)
+
+// sshAgentChannel is a datachannel.DataChannel which acts as the agent of an SSH session.  Reading blocks until
+// DisconnectPort is called, the same as the agent keeping the session open until the connection to the SSH server is
+// closed, then returns the remaining output and io.EOF.  Calling any other DataChannel method panics.
+type sshAgentChannel struct {
+	datachannel.DataChannel
+	output       []byte
+	mu           sync.Mutex
+	received     bytes.Buffer
+	atDisconnect string // the data received when DisconnectPort was first called
+	disconnects  int
+	disconnected chan struct{}
+}
+
+func newSSHAgentChannel(output string) *sshAgentChannel {
+	return &sshAgentChannel{output: []byte(output), disconnected: make(chan struct{})}
+}
+
+func (a *sshAgentChannel) Read(b []byte) (int, error) {
+	<-a.disconnected
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.output) < 1 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, a.output)
+	a.output = a.output[n:]
+	return n, nil
+}
+
+func (a *sshAgentChannel) Write(b []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.received.Write(b)
+}
+
+// ReadFrom and WriteTo are used by io.Copy, and copy using the Read and Write methods.
+func (a *sshAgentChannel) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{a}, r)
+}
+
+func (a *sshAgentChannel) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, struct{ io.Reader }{a})
+}
+
+func (a *sshAgentChannel) DisconnectPort() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.disconnects++
+	if a.disconnects == 1 {
+		a.atDisconnect = a.received.String()
+		close(a.disconnected)
+	}
+	return nil
+}
+
+func TestCopySSH_StdinEOF(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty input", input: ""},
+		{name: "input before EOF", input: "SSH-2.0-OpenSSH_9.0\r\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			const output = "remaining output"
+
+			c := newSSHAgentChannel(output)
+			out := new(bytes.Buffer)
+
+			doneCh := make(chan error, 1)
+			go func() {
+				doneCh <- copySSH(c, strings.NewReader(tc.input), out)
+			}()
+
+			select {
+			case err := <-doneCh:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("session did not end after stdin reached EOF")
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			if c.disconnects != 1 {
+				t.Errorf("DisconnectToPort sent %d times, want 1", c.disconnects)
+			}
+
+			if c.atDisconnect != tc.input {
+				t.Errorf("sent %q before DisconnectToPort, want %q", c.atDisconnect, tc.input)
+			}
+
+			if out.String() != output {
+				t.Errorf("got output %q, want %q", out, output)
+			}
+		})
+	}
+}
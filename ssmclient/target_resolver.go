@@ -4,14 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 )
 
 var (
@@ -19,6 +24,15 @@ var (
 	ErrInvalidTargetFormat = errors.New("invalid target format")
 	// ErrNoInstanceFound is the error returned if a resolver was unable to find an instance.
 	ErrNoInstanceFound = errors.New("no instances returned from lookup")
+	// ErrAmbiguousTarget is the error returned if a resolver requiring a single match found more than 1 instance.
+	ErrAmbiguousTarget = errors.New("target matches more than 1 instance")
+	// ErrInstanceNotRunning is the error returned if a resolver using WithStateDiagnostics() only found instances which are not running.
+	ErrInstanceNotRunning = errors.New("matching instance is not running")
+	// ErrNameserverRequired is the error returned by NewPrivateZoneResolver if no nameserver is provided.
+	ErrNameserverRequired = errors.New("a nameserver for the private hosted zone is required")
+
+	instanceIDRe  = regexp.MustCompile(`^m?i-[[:xdigit:]]{8,}$`)
+	instanceARNRe = regexp.MustCompile(`^arn:aws[a-z-]*:(?:ec2|ssm):[a-z0-9-]*:[0-9]*:(?:instance|managed-instance)/(m?i-[[:xdigit:]]{8,})$`)
 
 	// RFC 1918 and 6598 address blocks.
 	privateNets = []net.IPNet{
@@ -29,42 +43,131 @@ var (
 	}
 )
 
+// AmbiguousTargetError is the error returned by an EC2Resolver or ManagedInstanceResolver configured with
+// WithStrictSingleMatch() when more than 1 instance matches the target.  It wraps ErrAmbiguousTarget, and includes
+// all the matching instance IDs.
+type AmbiguousTargetError struct {
+	InstanceIDs []string
+}
+
+func (e *AmbiguousTargetError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrAmbiguousTarget, strings.Join(e.InstanceIDs, ", "))
+}
+
+func (e *AmbiguousTargetError) Unwrap() error {
+	return ErrAmbiguousTarget
+}
+
+// InstanceNotRunningError is the error returned by an EC2Resolver configured with WithStateDiagnostics() when no
+// running instance matches the target, but an instance in another state does.  It wraps ErrInstanceNotRunning,
+// and also matches ErrNoInstanceFound so existing error checks continue to work.
+type InstanceNotRunningError struct {
+	InstanceID string
+	State      string
+}
+
+func (e *InstanceNotRunningError) Error() string {
+	return fmt.Sprintf("%s: %s is %s", ErrInstanceNotRunning, e.InstanceID, e.State)
+}
+
+func (e *InstanceNotRunningError) Unwrap() error {
+	return ErrInstanceNotRunning
+}
+
+func (e *InstanceNotRunningError) Is(target error) bool {
+	return target == ErrNoInstanceFound
+}
+
+// ResolveError is the error returned by ResolveTargetWithOptions when none of the resolvers found an instance.
+// It wraps ErrNoInstanceFound, and includes the outcome of each resolver which was attempted.
+type ResolveError struct {
+	Attempts []ResolutionAttempt
+}
+
+func (e *ResolveError) Error() string {
+	msgs := make([]string, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", a.Resolver, a.Err))
+	}
+	return fmt.Sprintf("%s: [%s]", ErrNoInstanceFound, strings.Join(msgs, "; "))
+}
+
+func (e *ResolveError) Unwrap() error {
+	return ErrNoInstanceFound
+}
+
 // TargetResolver is the interface specification for something which knows how to resolve and EC2 instance identifier.
 type TargetResolver interface {
 	Resolve(string) (string, error)
 }
 
+// ContextTargetResolver is a TargetResolver which supports cancellation and timeouts using a context.Context.
+// All TargetResolver types provided by this package implement this interface.
+type ContextTargetResolver interface {
+	TargetResolver
+	ResolveContext(context.Context, string) (string, error)
+}
+
+// ResolutionAttempt records the outcome of resolving a target with a single TargetResolver.
+type ResolutionAttempt struct {
+	Resolver   string // the type name of the TargetResolver
+	InstanceID string // the instance ID found, if Err is nil
+	Err        error
+	Duration   time.Duration
+}
+
 // ResolveTarget attempts to find the instance ID of the target using a pre-defined resolution order.
-// The first check will see if the target is already in the format of an EC2 instance ID.  Next, if
-// the cfg parameter is not nil, checking by EC2 instance tags or private IPv4 IP address is performed.
-// Finally, resolving by DNS TXT record will be attempted.
+// The first check will see if the target is already in the format of an EC2 instance ID.  Next, targets
+// prefixed with ssm: are looked up in SSM Parameter Store, targets prefixed with r53: are looked up in a
+// Route53 hosted zone, then checking by EC2 instance tags or private IPv4 IP address is performed.  Next,
+// resolving by DNS TXT record will be attempted.  Finally, the target is looked up as the value of the instance
+// Name tag.  SSM managed (hybrid) instances are only found by their mi- instance ID, use ResolveTargetWithOptions
+// with the WithManagedInstances option (or a chain including a ManagedInstanceResolver) to search them by tags, IP
+// address, or computer name.
 func ResolveTarget(target string, cfg aws.Config) (string, error) {
-	resolvers := []TargetResolver{
+	return ResolveTargetChain(strings.TrimSpace(target), defaultResolvers(cfg)...)
+}
+
+// ResolveTargetVerbose runs every resolver used by ResolveTarget, in the same order, and returns the outcome of
+// each attempt.  Unlike ResolveTarget, resolution does not stop at the first resolver to find an instance, which
+// makes this useful for diagnosing which resolvers are matching (or failing to match) the target, and why.
+// ErrNoInstanceFound is returned if none of the resolvers found an instance.
+func ResolveTargetVerbose(target string, cfg aws.Config) ([]ResolutionAttempt, error) {
+	return ResolveTargetChainVerbose(strings.TrimSpace(target), defaultResolvers(cfg)...)
+}
+
+func defaultResolvers(cfg aws.Config) []TargetResolver {
+	return append([]TargetResolver{NewParameterResolver(cfg)}, instanceResolvers(cfg)...)
+}
+
+// instanceResolvers are the default resolvers which find an instance directly from the target.
+func instanceResolvers(cfg aws.Config) []TargetResolver {
+	return []TargetResolver{
+		NewRoute53Resolver(cfg),
 		NewTagResolver(cfg),
 		NewIPResolver(cfg),
+		NewDNSResolver(),
+		NewNameTagResolver(cfg),
 	}
-
-	return ResolveTargetChain(strings.TrimSpace(target), append(resolvers, NewDNSResolver())...)
 }
 
 // ResolveTargetChain attempts to find the instance ID of the target using the provided list of TargetResolvers.
-// The first check will always be to see if the target is already in the format of an EC2 instance ID before
-// moving on to the resolution logic of the provided TargetResolvers.  If a resolver returns an error, the next
-// resolver in the chain is checked.  If all resolvers fail to find an instance ID an error is returned.
+// The first check will always be to see if the target is already in the format of an EC2 instance ID (or the
+// ARN of an EC2 or SSM managed instance) before moving on to the resolution logic of the provided TargetResolvers.
+// If a resolver returns an error, the next resolver in the chain is checked, unless the error is
+// ErrAmbiguousTarget which is returned immediately.  If all resolvers fail to find an instance ID an error is
+// returned.
 func ResolveTargetChain(target string, resolvers ...TargetResolver) (inst string, err error) {
-	var matched bool
-	matched, err = regexp.MatchString(`^m?i-[[:xdigit:]]{8,}$`, target)
-	if err != nil {
-		return "", err
-	}
-
-	if matched {
-		return target, nil
+	if id, ok := instanceIDFromTarget(target); ok {
+		return id, nil
 	}
 
 	for _, res := range resolvers {
 		inst, err = res.Resolve(target)
 		if err != nil {
+			if errors.Is(err, ErrAmbiguousTarget) {
+				return "", err
+			}
 			continue
 		}
 		return inst, nil
@@ -72,14 +175,304 @@ func ResolveTargetChain(target string, resolvers ...TargetResolver) (inst string
 	return "", ErrNoInstanceFound
 }
 
+// EC2ResolverOption is a function which configures the behavior of the EC2Resolver used by the
+// TagResolver and IPResolver types.
+type EC2ResolverOption func(*EC2Resolver)
+
+// WithStrictSingleMatch configures the resolver to return an AmbiguousTargetError if more than 1 instance
+// matches the target, instead of using the 1st instance found.
+func WithStrictSingleMatch() EC2ResolverOption {
+	return func(r *EC2Resolver) {
+		r.strict = true
+	}
+}
+
+// WithStateDiagnostics configures the resolver to check for matching instances which are not running (stopped,
+// terminated, etc.) when no running instance is found, and return an InstanceNotRunningError if there are any.
+// This requires an additional DescribeInstances API call when resolution fails.
+func WithStateDiagnostics() EC2ResolverOption {
+	return func(r *EC2Resolver) {
+		r.diagnose = true
+	}
+}
+
+// WithInstanceStates configures the resolver to match instances in any of the provided states (ex. running, pending,
+// stopping), instead of only running instances.  Calling this with no states restores the default (running only).
+func WithInstanceStates(states ...string) EC2ResolverOption {
+	return func(r *EC2Resolver) {
+		r.states = states
+	}
+}
+
+// WithInstanceSelector configures the resolver to call the provided function when more than 1 instance matches
+// the target, instead of using the 1st instance found.  The function returns the ID of the chosen instance, or an
+// error which is returned by the resolver.  The selector is used instead of the WithStrictSingleMatch() option.
+func WithInstanceSelector(f func([]types.Instance) (string, error)) EC2ResolverOption {
+	return func(r *EC2Resolver) {
+		r.selector = f
+	}
+}
+
+// ResolveTargetChainVerbose runs every one of the provided resolvers against the target, and returns the outcome
+// of each attempt.  If the target is already in the format of an EC2 instance ID or ARN, no resolvers are run.
+// ErrNoInstanceFound is returned if none of the resolvers found an instance.
+func ResolveTargetChainVerbose(target string, resolvers ...TargetResolver) ([]ResolutionAttempt, error) {
+	if id, ok := instanceIDFromTarget(target); ok {
+		return []ResolutionAttempt{{Resolver: "InstanceID", InstanceID: id}}, nil
+	}
+
+	var found bool
+	attempts := make([]ResolutionAttempt, 0, len(resolvers))
+
+	for _, res := range resolvers {
+		start := time.Now()
+		inst, err := res.Resolve(target)
+
+		attempts = append(attempts, ResolutionAttempt{
+			Resolver:   resolverName(res),
+			InstanceID: inst,
+			Err:        err,
+			Duration:   time.Since(start),
+		})
+
+		if err == nil {
+			found = true
+		}
+	}
+
+	if !found {
+		return attempts, ErrNoInstanceFound
+	}
+	return attempts, nil
+}
+
+// ResolveOption is a function which configures the behavior of ResolveTargetWithOptions.
+type ResolveOption func(*resolveOptions)
+
+type resolveOptions struct {
+	timeout          time.Duration
+	resolvers        []TargetResolver
+	resolversSet     bool
+	region           string
+	managedInstances bool
+}
+
+// chain returns the resolvers set using WithResolvers, or the default resolvers for cfg configured by the options.
+func (o *resolveOptions) chain(cfg aws.Config) []TargetResolver {
+	if o.resolversSet {
+		return o.resolvers
+	}
+
+	if len(o.region) > 0 {
+		cfg = cfg.Copy()
+		cfg.Region = o.region
+	}
+
+	resolvers := defaultResolvers(cfg)
+	if o.managedInstances {
+		resolvers = append(resolvers, NewManagedInstanceResolver(cfg))
+	}
+	return resolvers
+}
+
+// WithPerResolverTimeout sets the maximum amount of time each resolver is allowed to run.  Each resolver gets
+// its own timeout, so a single slow resolver can not consume the time allowed by the context for the others.
+func WithPerResolverTimeout(d time.Duration) ResolveOption {
+	return func(o *resolveOptions) {
+		o.timeout = d
+	}
+}
+
+// WithResolvers sets the resolvers used to find the target, in the order provided, instead of the resolvers
+// used by ResolveTarget.  If no resolvers are provided, targets which are not an instance ID or ARN are not found.
+func WithResolvers(resolvers ...TargetResolver) ResolveOption {
+	return func(o *resolveOptions) {
+		o.resolvers = resolvers
+		o.resolversSet = true
+	}
+}
+
+// WithRegion sets the AWS region used by the default resolvers, instead of the region of the aws.Config.  This has
+// no effect on resolvers set using WithResolvers, which are configured when they are created.
+func WithRegion(region string) ResolveOption {
+	return func(o *resolveOptions) {
+		o.region = region
+	}
+}
+
+// WithManagedInstances searches SSM managed (hybrid) instances by tags, IP address, or computer name, using a
+// ManagedInstanceResolver after the default resolvers.  Listing the managed instances can be slow in accounts with
+// many of them, so this is not done by ResolveTarget.  This has no effect on resolvers set using WithResolvers.
+func WithManagedInstances() ResolveOption {
+	return func(o *resolveOptions) {
+		o.managedInstances = true
+	}
+}
+
+// ResolveTargetWithContext attempts to find the instance ID of the target in the same way as ResolveTarget, using
+// the provided context for the AWS API calls and DNS lookups made by the resolvers.  This is the same as calling
+// ResolveTargetWithOptions without any options.
+func ResolveTargetWithContext(ctx context.Context, target string, cfg aws.Config) (string, error) {
+	return ResolveTargetWithOptions(ctx, target, cfg)
+}
+
+// ResolveTargetWithOptions attempts to find the instance ID of the target in the same way as ResolveTarget, with
+// resolution bounded by the provided context.  Resolvers which don't implement ContextTargetResolver can not be
+// interrupted, and will continue to run in the background after their timeout expires.  If no resolver finds
+// an instance, a ResolveError is returned containing the reason each resolver failed.  If the context is done,
+// resolution stops and the context error is returned.
+func ResolveTargetWithOptions(ctx context.Context, target string, cfg aws.Config, opts ...ResolveOption) (string, error) {
+	target = strings.TrimSpace(target)
+	if id, ok := instanceIDFromTarget(target); ok {
+		return id, nil
+	}
+
+	o := new(resolveOptions)
+	for _, f := range opts {
+		f(o)
+	}
+
+	resolvers := o.chain(cfg)
+	if len(resolvers) < 1 {
+		return "", ErrNoInstanceFound
+	}
+
+	rerr := new(ResolveError)
+	for _, res := range resolvers {
+		start := time.Now()
+		inst, err := resolveWithTimeout(ctx, o.timeout, res, target)
+		if err == nil {
+			return inst, nil
+		}
+
+		if errors.Is(err, ErrAmbiguousTarget) {
+			return "", err
+		}
+
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		rerr.Attempts = append(rerr.Attempts, ResolutionAttempt{
+			Resolver: resolverName(res),
+			Err:      err,
+			Duration: time.Since(start),
+		})
+	}
+	return "", rerr
+}
+
+// resolveWithTimeout runs the resolver using a context derived from ctx, limited by the timeout (if > 0).
+func resolveWithTimeout(ctx context.Context, timeout time.Duration, res TargetResolver, target string) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if r, ok := res.(ContextTargetResolver); ok {
+		return r.ResolveContext(ctx, target)
+	}
+
+	type result struct {
+		inst string
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		inst, err := res.Resolve(target)
+		ch <- result{inst, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.inst, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func resolverName(res TargetResolver) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", res), "*")
+}
+
+// NewChainResolver is a TargetResolver which tries each of the resolvers in order, the same as ResolveTargetChain,
+// so that a resolver chain can be used where a single TargetResolver is expected (like NewCachingResolver).
+func NewChainResolver(resolvers ...TargetResolver) *ChainResolver {
+	return &ChainResolver{resolvers: resolvers}
+}
+
+// NewDefaultResolver is a ChainResolver using the same resolvers, in the same order, as ResolveTarget.
+func NewDefaultResolver(cfg aws.Config) *ChainResolver {
+	return NewChainResolver(defaultResolvers(cfg)...)
+}
+
+// NewCachingResolver is a TargetResolver which remembers the instance IDs found by the inner resolver for the ttl
+// duration, to avoid repeated (and possibly throttled) API calls when the same target is resolved many times.
+func NewCachingResolver(inner TargetResolver, ttl time.Duration) *CachingResolver {
+	return &CachingResolver{inner: inner, ttl: ttl, cache: make(map[string]cachedTarget)}
+}
+
 // NewTagResolver is a TargetResolver which knows how to find an EC2 instance using tags.
-func NewTagResolver(cfg aws.Config) *TagResolver {
-	return &TagResolver{&EC2Resolver{cfg: cfg}}
+func NewTagResolver(cfg aws.Config, opts ...EC2ResolverOption) *TagResolver {
+	return &TagResolver{newEC2Resolver(cfg, opts...)}
+}
+
+// NewNameTagResolver is a TargetResolver which knows how to find an EC2 instance using the value of the Name tag.
+func NewNameTagResolver(cfg aws.Config, opts ...EC2ResolverOption) *NameTagResolver {
+	return &NameTagResolver{newEC2Resolver(cfg, opts...)}
+}
+
+// NewManagedInstanceResolver is a TargetResolver which knows how to find an SSM managed (hybrid) instance using tags,
+// IP address, or computer name.  Only the WithStrictSingleMatch() option applies to managed instances, the other
+// EC2ResolverOption values are ignored.
+func NewManagedInstanceResolver(cfg aws.Config, opts ...EC2ResolverOption) *ManagedInstanceResolver {
+	return &ManagedInstanceResolver{cfg: cfg, strict: newEC2Resolver(cfg, opts...).strict}
+}
+
+// NewPrivateZoneResolver is a TargetResolver which knows how to find an EC2 instance using the A record of a host name
+// in a Route53 private hosted zone.  The nameserver is the host (and optional port, default 53) of a DNS server which
+// can answer queries for the private zone, like a Route53 Resolver inbound endpoint, and is required.  Unlike the
+// IPResolver used by ResolveTarget, which looks up host names using the system DNS resolver (so only finds private
+// zone records inside the VPC), the host name is only looked up using the nameserver, and only private addresses
+// are matched.  This resolver is not used by ResolveTarget, add it to a resolver chain (see ResolveTargetChain and
+// WithResolvers) to use it.  ErrNameserverRequired is returned if nameserver is empty.
+func NewPrivateZoneResolver(cfg aws.Config, nameserver string, opts ...EC2ResolverOption) (*PrivateZoneResolver, error) {
+	nameserver = strings.TrimSpace(nameserver)
+	if len(nameserver) < 1 {
+		return nil, ErrNameserverRequired
+	}
+
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+
+	r := &PrivateZoneResolver{EC2Resolver: newEC2Resolver(cfg, opts...)}
+	r.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, nameserver)
+		},
+	}
+	return r, nil
+}
+
+// NewRoute53Resolver is a TargetResolver which knows how to find an EC2 instance using the A record of a host name in
+// a Route53 hosted zone, looked up using the Route53 API.
+func NewRoute53Resolver(cfg aws.Config, opts ...EC2ResolverOption) *Route53Resolver {
+	return &Route53Resolver{newEC2Resolver(cfg, opts...)}
 }
 
 // NewIPResolver is a TargetResolver which knows how to find an EC2 instance using the private IPv4 address.
-func NewIPResolver(cfg aws.Config) *IPResolver {
-	return &IPResolver{&EC2Resolver{cfg: cfg}}
+func NewIPResolver(cfg aws.Config, opts ...EC2ResolverOption) *IPResolver {
+	return &IPResolver{newEC2Resolver(cfg, opts...)}
+}
+
+// NewParameterResolver is a TargetResolver which knows how to find an EC2 instance using the value of an SSM parameter.
+func NewParameterResolver(cfg aws.Config) *ParameterResolver {
+	return &ParameterResolver{cfg: cfg}
 }
 
 // NewDNSResolver is a TargetResolver which knows how to find an EC2 instance using DNS TXT record lookups.
@@ -87,6 +480,75 @@ func NewDNSResolver() *DNSResolver {
 	return new(DNSResolver)
 }
 
+/*
+ *  Chain Resolver attempts to find an instance using each of its resolvers in order, returning the 1st instance ID
+ *  found.  Targets which are already an instance ID or ARN are returned without using the resolvers.  If none of the
+ *  resolvers find an instance, a ResolveError is returned, and an ErrAmbiguousTarget error is returned immediately.
+ */
+type ChainResolver struct {
+	resolvers []TargetResolver
+}
+
+func (r *ChainResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *ChainResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	return ResolveTargetWithOptions(ctx, target, aws.Config{}, WithResolvers(r.resolvers...))
+}
+
+/*
+ *  Caching Resolver returns the instance ID previously found for the target by the inner resolver, if it was found
+ *  less than the ttl duration ago.  Expired or missing entries are resolved using the inner resolver, and errors
+ *  are not cached.  It is safe to use from multiple goroutines.
+ */
+type CachingResolver struct {
+	inner TargetResolver
+	ttl   time.Duration
+	mu    sync.Mutex
+	cache map[string]cachedTarget
+}
+
+type cachedTarget struct {
+	id      string
+	expires time.Time
+}
+
+func (r *CachingResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *CachingResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	key := strings.TrimSpace(target)
+
+	r.mu.Lock()
+	e, ok := r.cache[key]
+	r.mu.Unlock()
+
+	if ok && time.Now().Before(e.expires) {
+		return e.id, nil
+	}
+
+	id, err := resolveWithTimeout(ctx, 0, r.inner, target)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// remove expired entries, so the cache doesn't grow without bound in long running programs
+	now := time.Now()
+	for k, v := range r.cache {
+		if now.After(v.expires) {
+			delete(r.cache, k)
+		}
+	}
+
+	r.cache[key] = cachedTarget{id: id, expires: now.Add(r.ttl)}
+	return id, nil
+}
+
 /*
  * DNS Resolver attempts to find an instance using a DNS TXT record lookup.  The DNS record is expected
  * to resolve to the EC2 instance ID associated with the DNS name.  If the DNS record is not found, or if
@@ -95,7 +557,11 @@ func NewDNSResolver() *DNSResolver {
 type DNSResolver bool
 
 func (r *DNSResolver) Resolve(target string) (string, error) {
-	rr, err := net.LookupTXT(strings.TrimSpace(target))
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *DNSResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	rr, err := net.DefaultResolver.LookupTXT(ctx, strings.TrimSpace(target))
 	if err != nil {
 		return "", err
 	}
@@ -110,28 +576,323 @@ func (r *DNSResolver) Resolve(target string) (string, error) {
 	return "", ErrNoInstanceFound
 }
 
+/*
+ *  Parameter Resolver attempts to find an instance using the value of an SSM Parameter Store parameter.  The
+ *  expected format is ssm:parameter_name (ex. ssm:/infra/hosts/web01).  If the parameter value is not an
+ *  instance ID, it is resolved using the tag, IP address, and DNS resolvers, so the parameter can hold any
+ *  target format supported by those resolvers.  If the target doesn't have the ssm: prefix, or the parameter
+ *  can not be read, an error is returned.
+ */
+type ParameterResolver struct {
+	cfg aws.Config
+}
+
+func (r *ParameterResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *ParameterResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	trimmed := strings.TrimSpace(target)
+	if !strings.HasPrefix(trimmed, `ssm:`) || len(trimmed) < 5 {
+		return "", ErrInvalidTargetFormat
+	}
+
+	in := &ssm.GetParameterInput{
+		Name:           aws.String(strings.TrimPrefix(trimmed, `ssm:`)),
+		WithDecryption: aws.Bool(true),
+	}
+
+	out, err := ssm.NewFromConfig(clientConfig(r.cfg)).GetParameter(ctx, in)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	if out.Parameter != nil {
+		value = strings.TrimSpace(aws.ToString(out.Parameter.Value))
+	}
+
+	if isInstanceID(value) {
+		return value, nil
+	}
+	return ResolveTargetWithOptions(ctx, value, r.cfg, WithResolvers(instanceResolvers(r.cfg)...))
+}
+
 /*
  *  Tag Resolver attempts to find an instance using instance tags.  The expected format is tag_key:tag_value
- *  (ex. hostname:web0).  If the target to resolve doesn't look like a a colon-separated tag key:value pair,
- *  or no instance is found, an error is returned.  At most, 1 instance ID is returned; if more than 1 match
- *  is found, only the 1st element of the instances list is returned.  The nature of the AWS EC2 API will not
- *  guarantee ordering of the instances list.
+ *  (ex. hostname:web0).  Multiple tags can be matched by separating them with commas, and each tag may also
+ *  be written as tag_key=tag_value with an optional tag: prefix (ex. tag:Name=web,tag:env=prod), in which case
+ *  an instance must match all the tags.  If any of the comma-separated parts is not a tag key/value pair, the
+ *  whole target is treated as a single key:value pair, so tag values containing commas still work.  If the
+ *  target to resolve doesn't look like a tag key/value pair, or no instance is found, an error is returned.
+ *  At most, 1 instance ID is returned; if more than 1 match is found, only the 1st element of the instances
+ *  list is returned.  The nature of the AWS EC2 API will not guarantee ordering of the instances list.
  */
 type TagResolver struct {
 	*EC2Resolver
 }
 
 func (r *TagResolver) Resolve(target string) (string, error) {
-	spec := strings.SplitN(strings.TrimSpace(target), `:`, 2)
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *TagResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	filters, err := parseTagFilters(strings.TrimSpace(target))
+	if err != nil {
+		return "", err
+	}
+	return r.EC2Resolver.ResolveContext(ctx, filters...)
+}
+
+// parseTagFilters converts the TagResolver target format in to the EC2 filters for each tag.
+func parseTagFilters(target string) ([]types.Filter, error) {
+	parts := strings.Split(target, `,`)
+	filters := make([]types.Filter, 0, len(parts))
+
+	for _, p := range parts {
+		k, v, ok := parseTagPair(strings.TrimSpace(p))
+		if !ok {
+			filters = nil
+			break
+		}
+		filters = append(filters, tagFilter(k, v))
+	}
+
+	if len(filters) > 0 {
+		return filters, nil
+	}
+
+	// not a list of tags, fall back to the original single key:value format
+	spec := strings.SplitN(target, `:`, 2)
 	if len(spec) < 2 {
+		return nil, ErrInvalidTargetFormat
+	}
+	return []types.Filter{tagFilter(spec[0], spec[1])}, nil
+}
+
+// parseTagPair splits a single tag:key=value, key:value, or key=value tag specification.
+func parseTagPair(spec string) (string, string, bool) {
+	if strings.HasPrefix(spec, `tag:`) && strings.Contains(spec, `=`) {
+		spec = strings.TrimPrefix(spec, `tag:`)
+	}
+
+	i := strings.IndexAny(spec, `:=`)
+	if i < 1 {
+		return "", "", false
+	}
+	return spec[:i], spec[i+1:], true
+}
+
+func tagFilter(key, value string) types.Filter {
+	return types.Filter{
+		Name:   aws.String(fmt.Sprintf(`tag:%s`, key)),
+		Values: []string{value},
+	}
+}
+
+/*
+ *  Name Tag Resolver attempts to find an instance using the value of the Name tag, without the key:value format
+ *  required by the Tag Resolver (ex. web0 is the same as Name:web0).  Targets which look like a tag key/value
+ *  pair or an IP address are not valid for this resolver, and return an error.  At most, 1 instance ID is
+ *  returned; if more than 1 match is found, only the 1st element of the instances list is returned.
+ */
+type NameTagResolver struct {
+	*EC2Resolver
+}
+
+func (r *NameTagResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *NameTagResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	name := strings.TrimSpace(target)
+	if len(name) < 1 || strings.ContainsAny(name, `:=`) || net.ParseIP(name) != nil {
 		return "", ErrInvalidTargetFormat
 	}
+	return r.EC2Resolver.ResolveContext(ctx, tagFilter("Name", name))
+}
 
-	f := types.Filter{
-		Name:   aws.String(fmt.Sprintf(`tag:%s`, spec[0])),
-		Values: []string{spec[1]},
+/*
+ *  Managed Instance Resolver attempts to find an SSM managed (mi-) instance, which are not returned by the EC2 API,
+ *  using the SSM DescribeInstanceInformation API.  Targets in the Tag Resolver format are matched using the tags of
+ *  the managed instance, any other target is compared to the IP address and computer name (with or without the
+ *  domain) reported by the agent, which requires listing all managed instances.  Targets with the ssm: or r53:
+ *  prefix are not checked, since those are handled by the Parameter and Route53 resolvers.  At most, 1 instance
+ *  ID is returned; if more than 1 match is found, only the 1st instance is returned, unless the resolver was
+ *  created using the WithStrictSingleMatch() option, in which case an AmbiguousTargetError is returned.  The
+ *  WithInstanceSelector() and WithInstanceStates() options don't apply, since managed instances are not EC2
+ *  instances and have no instance state.
+ */
+type ManagedInstanceResolver struct {
+	cfg    aws.Config
+	strict bool
+}
+
+func (r *ManagedInstanceResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *ManagedInstanceResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	trimmed := strings.TrimSpace(target)
+	if len(trimmed) < 1 || strings.HasPrefix(trimmed, `ssm:`) || strings.HasPrefix(trimmed, `r53:`) {
+		return "", ErrInvalidTargetFormat
+	}
+
+	filters := []ssmtypes.InstanceInformationStringFilter{
+		{Key: aws.String("ResourceType"), Values: []string{string(ssmtypes.ResourceTypeManagedInstance)}},
+	}
+
+	match := func(ssmtypes.InstanceInformation) bool { return true }
+	if tags, err := parseTagFilters(trimmed); err == nil {
+		for _, t := range tags {
+			filters = append(filters, ssmtypes.InstanceInformationStringFilter{Key: t.Name, Values: t.Values})
+		}
+	} else {
+		match = func(i ssmtypes.InstanceInformation) bool {
+			name := aws.ToString(i.ComputerName)
+			return aws.ToString(i.IPAddress) == trimmed || strings.EqualFold(name, trimmed) ||
+				strings.EqualFold(strings.SplitN(name, ".", 2)[0], trimmed)
+		}
+	}
+
+	in := &ssm.DescribeInstanceInformationInput{Filters: filters}
+	p := ssm.NewDescribeInstanceInformationPaginator(ssm.NewFromConfig(clientConfig(r.cfg)), in)
+
+	var ids []string
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		for _, i := range out.InstanceInformationList {
+			if i.InstanceId != nil && match(i) {
+				ids = append(ids, *i.InstanceId)
+			}
+		}
+	}
+
+	if len(ids) < 1 {
+		return "", ErrNoInstanceFound
 	}
-	return r.EC2Resolver.Resolve(f)
+
+	if len(ids) > 1 {
+		if r.strict {
+			return "", &AmbiguousTargetError{InstanceIDs: ids}
+		}
+		logger().Warnf("more than 1 instance found, using 1st value")
+	}
+	return ids[0], nil
+}
+
+/*
+ *  Private Zone Resolver attempts to find an instance by looking up the A record of the target host name, and
+ *  finding the instance with the resulting private IPv4 address using the EC2 API.  Public addresses are not used,
+ *  since they can't be in a private hosted zone.  If the target is an IP address, the host name doesn't resolve, or
+ *  doesn't resolve to a private address, an error is returned.  At most, 1 instance ID is returned; if more than 1
+ *  match is found, only the 1st element of the instances list is returned.
+ */
+type PrivateZoneResolver struct {
+	*EC2Resolver
+	resolver *net.Resolver
+}
+
+func (r *PrivateZoneResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *PrivateZoneResolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	trimmed := strings.TrimSpace(target)
+	if len(trimmed) < 1 || net.ParseIP(trimmed) != nil {
+		return "", ErrInvalidTargetFormat
+	}
+
+	addrs, err := r.resolver.LookupIPAddr(ctx, trimmed)
+	if err != nil {
+		return "", ErrInvalidTargetFormat
+	}
+
+	var privIP []string
+	for _, a := range addrs {
+		if v := a.IP.To4(); v != nil && isPrivateAddr(v) {
+			privIP = append(privIP, v.String())
+		}
+	}
+
+	if len(privIP) < 1 {
+		return "", ErrInvalidTargetFormat
+	}
+	return r.EC2Resolver.ResolveContext(ctx, types.Filter{Name: aws.String(`private-ip-address`), Values: privIP})
+}
+
+/*
+ *  Route53 Resolver attempts to find an instance using the IP address in the A record of a host name in a Route53
+ *  hosted zone.  The expected format is r53:zone_id/name (ex. r53:Z0123456789ABCDEFGHIJ/web0.internal.example.com).
+ *  Since the Route53 API is used instead of DNS, this works for private hosted zones from outside the VPC.  If the
+ *  target doesn't have the r53: prefix, or the zone doesn't have an A record for the name, an error is returned.
+ *  At most, 1 instance ID is returned; if more than 1 match is found, only the 1st element of the instances list is
+ *  returned.
+ */
+type Route53Resolver struct {
+	*EC2Resolver
+}
+
+func (r *Route53Resolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *Route53Resolver) ResolveContext(ctx context.Context, target string) (string, error) {
+	trimmed := strings.TrimSpace(target)
+	if !strings.HasPrefix(trimmed, `r53:`) {
+		return "", ErrInvalidTargetFormat
+	}
+
+	spec := strings.SplitN(strings.TrimPrefix(trimmed, `r53:`), `/`, 2)
+	if len(spec) < 2 || len(spec[0]) < 1 || len(spec[1]) < 1 {
+		return "", ErrInvalidTargetFormat
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(spec[1], `.`))
+	in := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(spec[0]),
+		StartRecordName: aws.String(name),
+		StartRecordType: r53types.RRTypeA,
+		MaxItems:        aws.Int32(1),
+	}
+
+	out, err := route53.NewFromConfig(clientConfig(r.cfg)).ListResourceRecordSets(ctx, in)
+	if err != nil {
+		return "", err
+	}
+
+	var pubIP, privIP []string
+	for _, rr := range out.ResourceRecordSets {
+		// the listing starts at the requested name, but returns the next record if there isn't an exact match
+		if rr.Type != r53types.RRTypeA || strings.TrimSuffix(strings.ToLower(aws.ToString(rr.Name)), `.`) != name {
+			continue
+		}
+
+		for _, v := range rr.ResourceRecords {
+			ip := net.ParseIP(aws.ToString(v.Value)).To4()
+			switch {
+			case ip == nil:
+				continue
+			case isPrivateAddr(ip):
+				privIP = append(privIP, ip.String())
+			default:
+				pubIP = append(pubIP, ip.String())
+			}
+		}
+	}
+
+	f := types.Filter{Name: aws.String(`private-ip-address`), Values: privIP}
+	if len(privIP) < 1 {
+		if len(pubIP) < 1 {
+			return "", ErrNoInstanceFound
+		}
+		f = types.Filter{Name: aws.String(`ip-address`), Values: pubIP}
+	}
+	return r.EC2Resolver.ResolveContext(ctx, f)
 }
 
 /*
@@ -146,6 +907,10 @@ type IPResolver struct {
 }
 
 func (r *IPResolver) Resolve(target string) (string, error) {
+	return r.ResolveContext(context.Background(), target)
+}
+
+func (r *IPResolver) ResolveContext(ctx context.Context, target string) (string, error) {
 	var pubIP, privIP []string
 	var targets []net.IP
 
@@ -155,11 +920,15 @@ func (r *IPResolver) Resolve(target string) (string, error) {
 
 	if ip == nil {
 		// didn't look like an IP address, attempt DNS resolution ... maybe we'll find something there
-		addrs, err := net.LookupIP(trimmed)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, trimmed)
 		if err != nil {
 			return "", ErrInvalidTargetFormat
 		}
-		targets = addrs
+
+		targets = make([]net.IP, 0, len(addrs))
+		for _, a := range addrs {
+			targets = append(targets, a.IP)
+		}
 	}
 
 	for _, t := range targets {
@@ -191,7 +960,34 @@ func (r *IPResolver) Resolve(target string) (string, error) {
 		f.Values = pubIP
 	}
 
-	return r.EC2Resolver.Resolve(f)
+	return r.EC2Resolver.ResolveContext(ctx, f)
+}
+
+// isInstanceID checks if the target is already in the format of an EC2 (i-) or SSM managed (mi-) instance ID.
+func isInstanceID(target string) bool {
+	return instanceIDRe.MatchString(target)
+}
+
+// instanceIDFromTarget returns the instance ID if the target is an EC2 or SSM managed instance ID, or the ARN of one
+// (ex. arn:aws:ec2:us-east-1:123456789012:instance/i-0123456789abcdef0).
+func instanceIDFromTarget(target string) (string, bool) {
+	if isInstanceID(target) {
+		return target, true
+	}
+
+	if m := instanceARNRe.FindStringSubmatch(target); m != nil {
+		return m[1], true
+	}
+	return "", false
+}
+
+// sessionTarget returns the value for the StartSession Target parameter, converting an instance ARN to the
+// instance ID required by the API.  Any other target is returned unchanged.
+func sessionTarget(target string) *string {
+	if id, ok := instanceIDFromTarget(strings.TrimSpace(target)); ok {
+		return aws.String(id)
+	}
+	return aws.String(target)
 }
 
 func isPrivateAddr(addr net.IP) bool {
@@ -205,28 +1001,104 @@ func isPrivateAddr(addr net.IP) bool {
 
 /*
  *  EC2 Resolver calls the EC2 DescribeInstances API with a provided filter, which will return at most 1
- *  instance ID. If more than 1 instance matches the filter, the 1st instance ID in the list is returned.
+ *  instance ID. Only running instances are matched, unless the resolver was created using the
+ *  WithInstanceStates() option. If more than 1 instance matches the filter, the instance ID returned by the
+ *  function provided with the WithInstanceSelector() option is used.  Otherwise, the 1st instance ID in the list
+ *  is returned, unless the resolver was created using the WithStrictSingleMatch() option, in which case an
+ *  AmbiguousTargetError is returned.
  */
 type EC2Resolver struct {
-	cfg aws.Config
+	cfg      aws.Config
+	strict   bool
+	diagnose bool
+	states   []string
+	selector func([]types.Instance) (string, error)
+}
+
+func newEC2Resolver(cfg aws.Config, opts ...EC2ResolverOption) *EC2Resolver {
+	r := &EC2Resolver{cfg: cfg}
+	for _, o := range opts {
+		o(r)
+	}
+	return r
 }
 
 func (r *EC2Resolver) Resolve(filter ...types.Filter) (string, error) {
-	filter = append(filter, types.Filter{Name: aws.String("instance-state-name"), Values: []string{"running"}})
-	o, err := ec2.NewFromConfig(r.cfg).DescribeInstances(context.Background(), &ec2.DescribeInstancesInput{Filters: filter})
+	return r.ResolveContext(context.Background(), filter...)
+}
+
+// ResolveContext is the same as Resolve, using the provided context for the DescribeInstances API call.
+func (r *EC2Resolver) ResolveContext(ctx context.Context, filter ...types.Filter) (string, error) {
+	client := ec2.NewFromConfig(clientConfig(r.cfg))
+
+	states := r.states
+	if len(states) < 1 {
+		states = []string{string(types.InstanceStateNameRunning)}
+	}
+
+	running := append(filter[:len(filter):len(filter)], types.Filter{Name: aws.String("instance-state-name"), Values: states})
+	instances, err := describeInstances(ctx, client, running)
 	if err != nil {
 		return "", err
 	}
 
+	ids := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		ids = append(ids, *inst.InstanceId)
+	}
+
+	if len(ids) < 1 {
+		if r.diagnose {
+			return "", notRunningError(ctx, client, filter)
+		}
+		return "", ErrNoInstanceFound
+	}
+
+	if len(ids) > 1 {
+		if r.selector != nil {
+			return r.selector(instances)
+		}
+
+		if r.strict {
+			return "", &AmbiguousTargetError{InstanceIDs: ids}
+		}
+		logger().Warnf("more than 1 instance found, using 1st value")
+	}
+
+	return ids[0], nil
+}
+
+// describeInstances returns the instances matching the filters which have an instance ID.
+func describeInstances(ctx context.Context, client *ec2.Client, filter []types.Filter) ([]types.Instance, error) {
+	o, err := client.DescribeInstances(ctx, &ec2.DescribeInstancesInput{Filters: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []types.Instance
 	for _, res := range o.Reservations {
-		if len(res.Instances) > 0 {
-			if len(res.Instances) > 1 {
-				log.Print("WARNING: more than 1 instance found, using 1st value")
+		for _, inst := range res.Instances {
+			// shouldn't happen, but guard against a malformed API response
+			if inst.InstanceId == nil {
+				continue
 			}
-
-			return *res.Instances[0].InstanceId, nil
+			instances = append(instances, inst)
 		}
 	}
+	return instances, nil
+}
 
-	return "", ErrNoInstanceFound
+// notRunningError looks up instances matching the filters in any state, and returns an InstanceNotRunningError
+// for the 1st one found.  If there are none, or the lookup fails, ErrNoInstanceFound is returned.
+func notRunningError(ctx context.Context, client *ec2.Client, filter []types.Filter) error {
+	instances, err := describeInstances(ctx, client, filter)
+	if err != nil || len(instances) < 1 {
+		return ErrNoInstanceFound
+	}
+
+	e := &InstanceNotRunningError{InstanceID: *instances[0].InstanceId, State: "unknown"}
+	if instances[0].State != nil {
+		e.State = string(instances[0].State.Name)
+	}
+	return e
 }
@@ -0,0 +1,199 @@
+package ssmclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// staticHTTPClient is an aws.HTTPClient which returns the XML document as the response to every request.
+type staticHTTPClient string
+
+func (c staticHTTPClient) Do(*http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml;charset=UTF-8"}},
+		Body:       io.NopCloser(strings.NewReader(string(c))),
+	}, nil
+}
+
+// describeInstancesResponse returns a DescribeInstances API response containing a single reservation with an instance
+// for each of the IDs, an empty ID is an instance without an instanceId element.
+func describeInstancesResponse(ids ...string) staticHTTPClient {
+	b := new(strings.Builder)
+	b.WriteString(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">`)
+	b.WriteString(`<requestId>test</requestId><reservationSet><item><reservationId>r-1</reservationId><instancesSet>`)
+
+	for _, id := range ids {
+		b.WriteString(`<item>`)
+		if len(id) > 0 {
+			b.WriteString(`<instanceId>` + id + `</instanceId>`)
+		}
+		b.WriteString(`<instanceState><code>16</code><name>running</name></instanceState></item>`)
+	}
+
+	b.WriteString(`</instancesSet></item></reservationSet></DescribeInstancesResponse>`)
+	return staticHTTPClient(b.String())
+}
+
+func testConfig(client aws.HTTPClient) aws.Config {
+	return aws.Config{
+		Region:     "us-east-1",
+		HTTPClient: client,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+		}),
+	}
+}
+
+func TestEC2Resolver_NilInstanceId(t *testing.T) {
+	const (
+		id1 = "i-0123456789abcdef0"
+		id2 = "i-0fedcba9876543210"
+	)
+
+	var selected []types.Instance
+	selector := func(instances []types.Instance) (string, error) {
+		selected = instances
+		return *instances[len(instances)-1].InstanceId, nil
+	}
+
+	tests := []struct {
+		name     string
+		ids      []string
+		opts     []EC2ResolverOption
+		want     string
+		wantErr  error
+		selected int // the number of instances passed to the selector
+	}{
+		{name: "no instances", wantErr: ErrNoInstanceFound},
+		{name: "only nil", ids: []string{""}, wantErr: ErrNoInstanceFound},
+		{name: "nil first", ids: []string{"", id1}, want: id1},
+		{name: "nil last", ids: []string{id1, ""}, want: id1},
+		{name: "nil not ambiguous", ids: []string{"", id1, ""}, opts: []EC2ResolverOption{WithStrictSingleMatch()},
+			want: id1},
+		{name: "strict", ids: []string{id1, "", id2}, opts: []EC2ResolverOption{WithStrictSingleMatch()},
+			wantErr: ErrAmbiguousTarget},
+		{name: "selector", ids: []string{id1, "", id2, ""}, opts: []EC2ResolverOption{WithInstanceSelector(selector)},
+			want: id2, selected: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			selected = nil
+
+			r := NewTagResolver(testConfig(describeInstancesResponse(tc.ids...)), tc.opts...)
+			got, err := r.Resolve("Name:web0")
+
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf("got error %v, want %v", err, tc.wantErr)
+				}
+
+				var ambiguous *AmbiguousTargetError
+				if errors.As(err, &ambiguous) && len(ambiguous.InstanceIDs) != 2 {
+					t.Errorf("got ambiguous instances %v, want [%s %s]", ambiguous.InstanceIDs, id1, id2)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.want {
+				t.Errorf("got instance %q, want %q", got, tc.want)
+			}
+
+			if len(selected) != tc.selected {
+				t.Errorf("selector was passed %d instances, want %d", len(selected), tc.selected)
+			}
+
+			for _, inst := range selected {
+				if inst.InstanceId == nil {
+					t.Error("selector was passed an instance without an InstanceId")
+				}
+			}
+		})
+	}
+}
+
+func TestResolveOptions_ManagedInstances(t *testing.T) {
+	cfg := testConfig(describeInstancesResponse())
+	custom := NewTagResolver(cfg)
+
+	isManaged := func(r TargetResolver) bool {
+		_, ok := r.(*ManagedInstanceResolver)
+		return ok
+	}
+
+	tests := []struct {
+		name    string
+		opts    []ResolveOption
+		managed bool // the chain ends with a ManagedInstanceResolver
+		custom  bool // the chain is only the custom resolver
+	}{
+		{name: "default"},
+		{name: "managed instances", opts: []ResolveOption{WithManagedInstances()}, managed: true},
+		{name: "custom resolvers", opts: []ResolveOption{WithResolvers(custom), WithManagedInstances()}, custom: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := new(resolveOptions)
+			for _, f := range tc.opts {
+				f(o)
+			}
+			chain := o.chain(cfg)
+
+			if tc.custom {
+				if len(chain) != 1 || chain[0] != TargetResolver(custom) {
+					t.Errorf("got resolvers %T, want only the custom resolver", chain)
+				}
+				return
+			}
+
+			for i, r := range chain {
+				if isManaged(r) && (!tc.managed || i != len(chain)-1) {
+					t.Errorf("ManagedInstanceResolver at position %d of %d", i+1, len(chain))
+				}
+			}
+
+			if tc.managed && !isManaged(chain[len(chain)-1]) {
+				t.Errorf("chain ends with %T, want a ManagedInstanceResolver", chain[len(chain)-1])
+			}
+		})
+	}
+
+	for _, r := range NewDefaultResolver(cfg).resolvers {
+		if isManaged(r) {
+			t.Error("default resolver chain includes a ManagedInstanceResolver")
+		}
+	}
+}
+
+func TestNewPrivateZoneResolver(t *testing.T) {
+	cfg := testConfig(describeInstancesResponse())
+
+	for _, ns := range []string{"", "  "} {
+		if _, err := NewPrivateZoneResolver(cfg, ns); !errors.Is(err, ErrNameserverRequired) {
+			t.Errorf("nameserver %q: got error %v, want %v", ns, err, ErrNameserverRequired)
+		}
+	}
+
+	r, err := NewPrivateZoneResolver(cfg, "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IP addresses are handled by the IPResolver, and are not looked up
+	if _, err = r.Resolve("10.0.0.10"); !errors.Is(err, ErrInvalidTargetFormat) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidTargetFormat)
+	}
+}
@@ -0,0 +1,83 @@
+package ssmclient
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Transcript stream names, used in the records written to ShellSessionInput.TranscriptWriter.
+const (
+	TranscriptOutput = "output"
+	TranscriptInput  = "input"
+)
+
+// transcript writes a timestamped record of the data sent over a shell session.  Each record is a single line with
+// the UTC time in RFC3339 format (with nanoseconds), the stream name, and the data as a Go quoted string, so control
+// characters and partial UTF-8 sequences are preserved.  For example:
+//
+//	2022-08-12T17:42:34.123456789Z output "$ ls\r\n"
+type transcript struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+}
+
+// record writes a transcript record for the data.  A failure writing the transcript is logged once, and stops the
+// recording, but does not affect the session.
+func (t *transcript) record(stream string, p []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.failed || len(p) < 1 {
+		return
+	}
+
+	if _, err := fmt.Fprintf(t.w, "%s %s %q\n", time.Now().UTC().Format(time.RFC3339Nano), stream, p); err != nil {
+		logger().Warnf("error writing session transcript, recording stopped: %v", err)
+		t.failed = true
+	}
+}
+
+// stream returns an io.Writer which records everything written to it in the transcript.
+func (t *transcript) stream(name string) io.Writer {
+	return &transcriptStream{t: t, name: name}
+}
+
+type transcriptStream struct {
+	t    *transcript
+	name string
+}
+
+// Write always succeeds, so a transcript error never interrupts the session when used with io.MultiWriter or
+// io.TeeReader.
+func (s *transcriptStream) Write(p []byte) (int, error) {
+	s.t.record(s.name, p)
+	return len(p), nil
+}
+
+// withTranscript returns the session input and output streams, and the initial command readers, wrapped to record
+// the session in the TranscriptWriter of the ShellSessionInput.  The output is always recorded, the input (including
+// the initial commands) is only recorded if TranscriptInput is set.  The streams are returned unchanged if there is
+// no TranscriptWriter.
+func withTranscript(opts *ShellSessionInput, in io.Reader, out io.Writer, initCmd []io.Reader) (io.Reader, io.Writer,
+	[]io.Reader) {
+	if opts.TranscriptWriter == nil {
+		return in, out, initCmd
+	}
+
+	t := &transcript{w: opts.TranscriptWriter}
+	out = io.MultiWriter(out, t.stream(TranscriptOutput))
+
+	if opts.TranscriptInput {
+		in = io.TeeReader(in, t.stream(TranscriptInput))
+
+		cmds := make([]io.Reader, 0, len(initCmd))
+		for _, cmd := range initCmd {
+			cmds = append(cmds, io.TeeReader(cmd, t.stream(TranscriptInput)))
+		}
+		initCmd = cmds
+	}
+	return in, out, initCmd
+}